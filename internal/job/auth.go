@@ -0,0 +1,145 @@
+package job
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// AppRoleRoleIDKey is the key of the role id in the approle secret
+	AppRoleRoleIDKey = "role-id"
+	// AppRoleSecretIDKey is the key of the secret id in the approle secret
+	AppRoleSecretIDKey = "secret-id"
+
+	jwtDir               = "/var/run/secrets/vault"
+	jwtPath              = jwtDir + "/token"
+	jwtExpirationSeconds = 600
+	appRoleDir           = "/etc/vault/approle"
+
+	// appRoleUser and appRoleGroup are the vault user of the vault CLI
+	// image, whose default user is root.
+	appRoleUser  = 100
+	appRoleGroup = 1000
+)
+
+// Auth configures the init container to log in to vault and to write the
+// vault token to the token volume.
+type Auth interface {
+	Apply(b *batchv1.Job)
+}
+
+// WithAuth configures the authentication of the init container.
+func WithAuth(a Auth) func(*batchv1.Job) {
+	return a.Apply
+}
+
+// KubernetesAuth logs in with the kubernetes auth method and the token of
+// the job's service account.
+type KubernetesAuth struct {
+	Role      string
+	MountPath string
+}
+
+// Apply configures the init container of b.
+func (a KubernetesAuth) Apply(b *batchv1.Job) {
+	WithVaultMountpath(a.MountPath)(b)
+	WithVaultRole(a.Role)(b)
+}
+
+// JWTAuth logs in with the jwt auth method and a projected service account
+// token for Audience. The login of the jwt and the kubernetes auth method
+// are the same, so the authenticator image is used with the projected token.
+type JWTAuth struct {
+	Role      string
+	MountPath string
+	Audience  string
+}
+
+// Apply configures the init container of b.
+func (a JWTAuth) Apply(b *batchv1.Job) {
+	KubernetesAuth{Role: a.Role, MountPath: a.MountPath}.Apply(b)
+
+	volume := apiv1.Volume{
+		Name: "vault-jwt",
+		VolumeSource: apiv1.VolumeSource{
+			Projected: &apiv1.ProjectedVolumeSource{
+				Sources: []apiv1.VolumeProjection{
+					{
+						ServiceAccountToken: &apiv1.ServiceAccountTokenProjection{
+							Audience:          a.Audience,
+							ExpirationSeconds: int64Ptr(jwtExpirationSeconds),
+							Path:              "token",
+						},
+					},
+				},
+			},
+		},
+	}
+	mount := apiv1.VolumeMount{
+		Name:      "vault-jwt",
+		MountPath: jwtDir,
+		ReadOnly:  true,
+	}
+	e := apiv1.EnvVar{
+		Name:  "SERVICE_ACCOUNT_TOKEN_PATH",
+		Value: jwtPath,
+	}
+
+	b.Spec.Template.Spec.Volumes = append(b.Spec.Template.Spec.Volumes, volume)
+	b.Spec.Template.Spec.InitContainers[0].VolumeMounts = append(b.Spec.Template.Spec.InitContainers[0].VolumeMounts, mount)
+	b.Spec.Template.Spec.InitContainers[0].Env = append(b.Spec.Template.Spec.InitContainers[0].Env, e)
+}
+
+// AppRoleAuth logs in with the approle auth method. The role id and the
+// secret id are taken from the kubernetes secret SecretName. The init
+// container runs the vault CLI, so its image has to provide vault and sh.
+// It runs as the vault user of the vault CLI image, so that it can run
+// with a restricted security context.
+type AppRoleAuth struct {
+	MountPath  string
+	SecretName string
+}
+
+// Apply configures the init container of b.
+func (a AppRoleAuth) Apply(b *batchv1.Job) {
+	WithVaultMountpath(a.MountPath)(b)
+
+	volume := apiv1.Volume{
+		Name: "vault-approle",
+		VolumeSource: apiv1.VolumeSource{
+			Secret: &apiv1.SecretVolumeSource{
+				SecretName: a.SecretName,
+				Items: []apiv1.KeyToPath{
+					{
+						Key:  AppRoleRoleIDKey,
+						Path: AppRoleRoleIDKey,
+					},
+					{
+						Key:  AppRoleSecretIDKey,
+						Path: AppRoleSecretIDKey,
+					},
+				},
+			},
+		},
+	}
+	mount := apiv1.VolumeMount{
+		Name:      "vault-approle",
+		MountPath: appRoleDir,
+		ReadOnly:  true,
+	}
+
+	c := &b.Spec.Template.Spec.InitContainers[0]
+	c.Command = []string{"sh", "-c",
+		`umask 077 && vault write -field=token "auth/${VAULT_AUTH_MOUNT_PATH}/login" ` +
+			"role_id=@" + appRoleDir + "/" + AppRoleRoleIDKey + " " +
+			"secret_id=@" + appRoleDir + "/" + AppRoleSecretIDKey + ` > "${VAULT_TOKEN_PATH}"`,
+	}
+
+	c.SecurityContext = &apiv1.SecurityContext{
+		RunAsUser:  int64Ptr(appRoleUser),
+		RunAsGroup: int64Ptr(appRoleGroup),
+	}
+
+	b.Spec.Template.Spec.Volumes = append(b.Spec.Template.Spec.Volumes, volume)
+	c.VolumeMounts = append(c.VolumeMounts, mount)
+}
@@ -0,0 +1,56 @@
+package job
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NewCronJob creates a cron job that runs the sync job j on schedule.
+func NewCronJob(schedule string, j *batchv1.Job, options ...func(*batchv1.CronJob)) *batchv1.CronJob {
+	c := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: Name,
+			Labels: map[string]string{
+				"job": Name,
+			},
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:          schedule,
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: j.Labels,
+				},
+				Spec: j.Spec,
+			},
+		},
+	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithConcurrencyPolicy configures how concurrent executions of the cron job are treated.
+func WithConcurrencyPolicy(policy batchv1.ConcurrencyPolicy) func(*batchv1.CronJob) {
+	return func(c *batchv1.CronJob) {
+		c.Spec.ConcurrencyPolicy = policy
+	}
+}
+
+// WithHistoryLimits configures how many successful and failed finished jobs are kept.
+func WithHistoryLimits(successful, failed int32) func(*batchv1.CronJob) {
+	return func(c *batchv1.CronJob) {
+		c.Spec.SuccessfulJobsHistoryLimit = int32Ptr(successful)
+		c.Spec.FailedJobsHistoryLimit = int32Ptr(failed)
+	}
+}
+
+// WithSuspend suspends subsequent executions of the cron job.
+func WithSuspend(suspend bool) func(*batchv1.CronJob) {
+	return func(c *batchv1.CronJob) {
+		c.Spec.Suspend = &suspend
+	}
+}
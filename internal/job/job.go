@@ -2,6 +2,7 @@
 package job
 
 import (
+	"fmt"
 	"sort"
 
 	batchv1 "k8s.io/api/batch/v1"
@@ -11,9 +12,13 @@ import (
 
 const (
 	// Name of the generated sync job
-	Name      = "vault-sync"
-	tokenDir  = "/home/vault"
-	tokenPath = tokenDir + "/.vault-token"
+	Name = "vault-sync"
+	// ServiceAccountName is the service account the sync job runs with
+	ServiceAccountName = "vault-auth"
+	// TruststoreKey is the key of the CA certificate in the truststore secret
+	TruststoreKey = "truststore.pem"
+	tokenDir      = "/home/vault"
+	tokenPath     = tokenDir + "/.vault-token"
 )
 
 // New creates a synchronize job that synchronizes secrets.
@@ -28,7 +33,7 @@ func New(options ...func(*batchv1.Job)) *batchv1.Job {
 		Spec: batchv1.JobSpec{
 			Template: apiv1.PodTemplateSpec{
 				Spec: apiv1.PodSpec{
-					ServiceAccountName: "vault-auth",
+					ServiceAccountName: ServiceAccountName,
 					RestartPolicy:      apiv1.RestartPolicyNever,
 					Volumes: []apiv1.Volume{
 						{
@@ -91,6 +96,69 @@ func New(options ...func(*batchv1.Job)) *batchv1.Job {
 	return b
 }
 
+// Validate checks that b still has the containers and the token volume
+// required to synchronize secrets, e.g. after it has been patched.
+func Validate(b *batchv1.Job) error {
+	if b.Labels["job"] != Name {
+		return fmt.Errorf("label job=%s is missing", Name)
+	}
+
+	spec := b.Spec.Template.Spec
+	if spec.RestartPolicy != apiv1.RestartPolicyNever && spec.RestartPolicy != apiv1.RestartPolicyOnFailure {
+		return fmt.Errorf("restart policy %q is not supported by jobs", spec.RestartPolicy)
+	}
+
+	if !hasTokenVolume(spec.Volumes) {
+		return fmt.Errorf("volume %s is missing", "vault-token")
+	}
+
+	for _, c := range []struct {
+		name       string
+		containers []apiv1.Container
+	}{
+		{"vault-auth", spec.InitContainers},
+		{"vault-sync", spec.Containers},
+	} {
+		if err := validateContainer(c.name, c.containers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasTokenVolume(volumes []apiv1.Volume) bool {
+	for _, v := range volumes {
+		if v.Name == "vault-token" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateContainer(name string, containers []apiv1.Container) error {
+	for _, c := range containers {
+		if c.Name != name {
+			continue
+		}
+
+		if c.Image == "" {
+			return fmt.Errorf("container %s has no image", name)
+		}
+
+		for _, m := range c.VolumeMounts {
+			if m.Name == "vault-token" && m.MountPath == tokenDir {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("container %s does not mount volume %s at %s", name, "vault-token", tokenDir)
+	}
+
+	return fmt.Errorf("container %s is missing", name)
+}
+
 func sortEnv(env []apiv1.EnvVar) {
 	sort.Slice(env, func(i, j int) bool {
 		return env[i].Name < env[j].Name
@@ -8,6 +8,9 @@ import (
 
 	"github.com/stretchr/testify/require"
 	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 )
 
@@ -18,7 +21,7 @@ func TestNew(t *testing.T) {
 	var tt = []struct {
 		name            string
 		expectedJobFile string
-		job             *batchv1.Job
+		job             runtime.Object
 	}{
 		{
 			"default job",
@@ -29,6 +32,7 @@ func TestNew(t *testing.T) {
 			"configured job",
 			"configured-job.yaml",
 			New(
+				WithAnnotation("key", "value"),
 				WithAuthenticatorImage("auth-image"),
 				WithBackoffLimit(3),
 				WithSecretPrefix("prefix"),
@@ -42,6 +46,119 @@ func TestNew(t *testing.T) {
 				WithVaultSecrets("secret/path"),
 			),
 		},
+		{
+			"vault namespace job",
+			"vault-namespace-job.yaml",
+			New(
+				WithVaultAddr("https://vault.io"),
+				WithVaultNamespace("team-a"),
+				WithVaultAuthNamespace("admin"),
+			),
+		},
+		{
+			"vault root namespace auth job",
+			"vault-root-namespace-job.yaml",
+			New(
+				WithVaultNamespace("team-a"),
+				WithVaultAuthNamespace(""),
+			),
+		},
+		{
+			"kubernetes auth job",
+			"kubernetes-auth-job.yaml",
+			New(
+				WithAuth(KubernetesAuth{Role: "role", MountPath: "kubernetes"}),
+			),
+		},
+		{
+			"jwt auth job",
+			"jwt-auth-job.yaml",
+			New(
+				WithAuth(JWTAuth{Role: "role", MountPath: "jwt", Audience: "vault"}),
+			),
+		},
+		{
+			"approle auth job",
+			"approle-auth-job.yaml",
+			New(
+				WithAuthenticatorImage("hashicorp/vault"),
+				WithAuth(AppRoleAuth{MountPath: "approle", SecretName: "approle-secret"}),
+			),
+		},
+		{
+			"hardened job",
+			"hardened-job.yaml",
+			New(
+				WithRestrictedSecurityContext(),
+				WithTruststore("truststore-secret"),
+			),
+		},
+		{
+			"hardened approle auth job",
+			"hardened-approle-auth-job.yaml",
+			New(
+				WithAuthenticatorImage("hashicorp/vault"),
+				WithAuth(AppRoleAuth{MountPath: "approle", SecretName: "approle-secret"}),
+				WithRestrictedSecurityContext(),
+			),
+		},
+		{
+			"scheduling job",
+			"scheduling-job.yaml",
+			New(
+				WithResources(apiv1.ResourceRequirements{
+					Requests: apiv1.ResourceList{
+						apiv1.ResourceCPU:    resource.MustParse("100m"),
+						apiv1.ResourceMemory: resource.MustParse("64Mi"),
+					},
+					Limits: apiv1.ResourceList{
+						apiv1.ResourceMemory: resource.MustParse("128Mi"),
+					},
+				}),
+				WithNodeSelector(map[string]string{"pool": "infra"}),
+				WithTolerations(apiv1.Toleration{
+					Key:      "dedicated",
+					Operator: apiv1.TolerationOpEqual,
+					Value:    "infra",
+					Effect:   apiv1.TaintEffectNoSchedule,
+				}),
+				WithAffinity(&apiv1.Affinity{
+					NodeAffinity: &apiv1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+							NodeSelectorTerms: []apiv1.NodeSelectorTerm{{
+								MatchExpressions: []apiv1.NodeSelectorRequirement{{
+									Key:      "kubernetes.io/arch",
+									Operator: apiv1.NodeSelectorOpIn,
+									Values:   []string{"amd64"},
+								}},
+							}},
+						},
+					},
+				}),
+				WithPriorityClassName("infra-critical"),
+				WithActiveDeadline(10*time.Minute),
+			),
+		},
+		{
+			"cron job",
+			"cronjob.yaml",
+			NewCronJob("*/15 * * * *",
+				New(
+					WithAuthenticatorImage("auth-image"),
+					WithBackoffLimit(3),
+					WithSecretPrefix("prefix"),
+					WithSynchronizerImage("sync-image"),
+					WithTruststore("truststore-secret"),
+					WithVaultAddr("https://vault.io"),
+					WithVaultMountpath("mountpath"),
+					WithVaultRole("role"),
+					WithVaultSecrets("secret/path"),
+				),
+				WithConcurrencyPolicy(batchv1.ReplaceConcurrent),
+				WithHistoryLimits(3, 1),
+				WithSuspend(true),
+			),
+		},
 	}
 
 	// nolint: scopelint
@@ -69,3 +186,39 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestValidate(t *testing.T) {
+	var tt = []struct {
+		name        string
+		modify      func(*batchv1.Job)
+		expectedErr string
+	}{
+		{"valid", func(*batchv1.Job) {}, ""},
+		{"missing label", func(b *batchv1.Job) { delete(b.Labels, "job") }, "label job=vault-sync is missing"},
+		{"restart policy", func(b *batchv1.Job) { b.Spec.Template.Spec.RestartPolicy = apiv1.RestartPolicyAlways }, `restart policy "Always"`},
+		{"missing volume", func(b *batchv1.Job) { b.Spec.Template.Spec.Volumes = nil }, "volume vault-token is missing"},
+		{"missing container", func(b *batchv1.Job) { b.Spec.Template.Spec.Containers[0].Name = "sync" }, "container vault-sync is missing"},
+		{"missing image", func(b *batchv1.Job) { b.Spec.Template.Spec.InitContainers[0].Image = "" }, "container vault-auth has no image"},
+		{"missing mount", func(b *batchv1.Job) { b.Spec.Template.Spec.Containers[0].VolumeMounts = nil }, "container vault-sync does not mount volume vault-token"},
+	}
+
+	// nolint: scopelint
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			b := New(
+				WithAuthenticatorImage("auth-image"),
+				WithSynchronizerImage("sync-image"),
+			)
+			tc.modify(b)
+
+			err := Validate(b)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
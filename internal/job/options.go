@@ -34,6 +34,37 @@ func WithVaultAddr(addr string) func(*batchv1.Job) {
 	}
 }
 
+// WithVaultNamespace configures the vault enterprise namespace of both
+// containers.
+func WithVaultNamespace(namespace string) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		if namespace == "" {
+			return
+		}
+
+		e := apiv1.EnvVar{
+			Name:  "VAULT_NAMESPACE",
+			Value: namespace,
+		}
+		b.Spec.Template.Spec.InitContainers[0].Env = setEnv(b.Spec.Template.Spec.InitContainers[0].Env, e)
+		b.Spec.Template.Spec.Containers[0].Env = setEnv(b.Spec.Template.Spec.Containers[0].Env, e)
+	}
+}
+
+// WithVaultAuthNamespace configures the vault enterprise namespace of the
+// init container, if authentication takes place in another namespace than
+// the secrets are read from. It has to be applied after WithVaultNamespace.
+// An empty namespace is the root namespace.
+func WithVaultAuthNamespace(namespace string) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		e := apiv1.EnvVar{
+			Name:  "VAULT_NAMESPACE",
+			Value: namespace,
+		}
+		b.Spec.Template.Spec.InitContainers[0].Env = setEnv(b.Spec.Template.Spec.InitContainers[0].Env, e)
+	}
+}
+
 // WithVaultMountpath configures the init container's vault mountpath.
 func WithVaultMountpath(path string) func(*batchv1.Job) {
 	return func(b *batchv1.Job) {
@@ -100,8 +131,8 @@ func WithTruststore(secretName string) func(*batchv1.Job) {
 					SecretName: secretName,
 					Items: []apiv1.KeyToPath{
 						{
-							Key:  "truststore.pem",
-							Path: "truststore.pem",
+							Key:  TruststoreKey,
+							Path: TruststoreKey,
 						},
 					},
 				},
@@ -114,7 +145,7 @@ func WithTruststore(secretName string) func(*batchv1.Job) {
 		}
 		e := apiv1.EnvVar{
 			Name:  "VAULT_CACERT",
-			Value: "/etc/pki/vault/truststore.pem",
+			Value: "/etc/pki/vault/" + TruststoreKey,
 		}
 
 		b.Spec.Template.Spec.Volumes = append(b.Spec.Template.Spec.Volumes, volume)
@@ -148,4 +179,117 @@ func WithSuffix(suffix string) func(*batchv1.Job) {
 	}
 }
 
+// WithAnnotation adds an annotation to the job.
+func WithAnnotation(key, value string) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		if b.Annotations == nil {
+			b.Annotations = map[string]string{}
+		}
+
+		b.Annotations[key] = value
+	}
+}
+
+// WithResources configures the resource requests and limits of both containers.
+func WithResources(resources apiv1.ResourceRequirements) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		b.Spec.Template.Spec.InitContainers[0].Resources = *resources.DeepCopy()
+		b.Spec.Template.Spec.Containers[0].Resources = *resources.DeepCopy()
+	}
+}
+
+// WithNodeSelector configures the labels of the nodes the pod can run on.
+func WithNodeSelector(selector map[string]string) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		if len(selector) == 0 {
+			return
+		}
+
+		b.Spec.Template.Spec.NodeSelector = selector
+	}
+}
+
+// WithTolerations adds tolerations to the pod.
+func WithTolerations(tolerations ...apiv1.Toleration) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		b.Spec.Template.Spec.Tolerations = append(b.Spec.Template.Spec.Tolerations, tolerations...)
+	}
+}
+
+// WithAffinity configures the scheduling constraints of the pod.
+func WithAffinity(affinity *apiv1.Affinity) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		b.Spec.Template.Spec.Affinity = affinity
+	}
+}
+
+// WithPriorityClassName configures the priority class of the pod.
+func WithPriorityClassName(name string) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		b.Spec.Template.Spec.PriorityClassName = name
+	}
+}
+
+// WithActiveDeadline limits the time the job may be active before it is
+// terminated.
+func WithActiveDeadline(d time.Duration) func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		seconds := int64(d.Seconds())
+		b.Spec.ActiveDeadlineSeconds = &seconds
+	}
+}
+
+// WithRestrictedSecurityContext configures the security context of both
+// containers to comply with the restricted pod security standard. The root
+// filesystem is read only, the token volume stays writable. A user and
+// group configured before, e.g. by AppRoleAuth, are kept.
+func WithRestrictedSecurityContext() func(*batchv1.Job) {
+	return func(b *batchv1.Job) {
+		for _, containers := range [][]apiv1.Container{b.Spec.Template.Spec.InitContainers, b.Spec.Template.Spec.Containers} {
+			for i := range containers {
+				sc := &apiv1.SecurityContext{}
+				if containers[i].SecurityContext != nil {
+					sc = containers[i].SecurityContext
+				}
+
+				containers[i].SecurityContext = &apiv1.SecurityContext{
+					RunAsUser:                sc.RunAsUser,
+					RunAsGroup:               sc.RunAsGroup,
+					RunAsNonRoot:             boolPtr(true),
+					AllowPrivilegeEscalation: boolPtr(false),
+					ReadOnlyRootFilesystem:   boolPtr(true),
+					Capabilities: &apiv1.Capabilities{
+						Drop: []apiv1.Capability{"ALL"},
+					},
+					SeccompProfile: &apiv1.SeccompProfile{
+						Type: apiv1.SeccompProfileTypeRuntimeDefault,
+					},
+				}
+			}
+		}
+	}
+}
+
 func int32Ptr(i int32) *int32 { return &i }
+
+func boolPtr(b bool) *bool { return &b }
+
+func int64Ptr(i int64) *int64 { return &i }
+
+// setEnv replaces the variable e in env or adds it. An empty value removes
+// the variable.
+func setEnv(env []apiv1.EnvVar, e apiv1.EnvVar) []apiv1.EnvVar {
+	result := []apiv1.EnvVar{}
+
+	for _, v := range env {
+		if v.Name != e.Name {
+			result = append(result, v)
+		}
+	}
+
+	if e.Value != "" {
+		result = append(result, e)
+	}
+
+	return result
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// CleanupOptions provides information required to delete finished sync jobs.
+type CleanupOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	// jobOptions resolves the retention limits of the namespace like the
+	// sync command does.
+	jobOptions
+
+	userSpecifiedKeepSuccessful int
+	userSpecifiedKeepFailed     int
+
+	rawConfig api.Config
+
+	genericclioptions.IOStreams
+}
+
+// NewCleanupOptions provides an instance of CleanupOptions with default values
+func NewCleanupOptions(streams genericclioptions.IOStreams) *CleanupOptions {
+	o := &CleanupOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		jobOptions:  jobOptions{config: vaultsync.DefaultConfig()},
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdCleanup provides a cobra command wrapping CleanupOptions
+func NewCmdCleanup(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdCleanup(NewCleanupOptions(streams))
+}
+
+// newCmdCleanup provides a cobra command wrapping o.
+func newCmdCleanup(o *CleanupOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "cleanup",
+		Short:        "Delete finished sync jobs",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	cmd.Flags().IntVar(&o.userSpecifiedKeepSuccessful, "keep-successful", vaultsync.DefaultKeepSuccessful,
+		fmt.Sprintf("The number of successful finished sync jobs to keep. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationKeepSuccessful))
+	cmd.Flags().IntVar(&o.userSpecifiedKeepFailed, "keep-failed", vaultsync.DefaultKeepFailed,
+		fmt.Sprintf("The number of failed finished sync jobs to keep. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationKeepFailed))
+	o.jobOptions.addSourceFlags(cmd.Flags())
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for deleting the jobs
+func (o *CleanupOptions) Complete(cmd *cobra.Command, args []string) error {
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	if err != nil {
+		return err
+	}
+
+	// the flags take precedence over the namespace annotations only if
+	// they are set
+	o.config.KeepSuccessful = strconv.Itoa(o.userSpecifiedKeepSuccessful)
+	o.config.KeepFailed = strconv.Itoa(o.userSpecifiedKeepFailed)
+
+	return o.loadProfile(o.configFlags, &o.rawConfig)
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *CleanupOptions) Validate() error {
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+	if err != nil {
+		return err
+	}
+
+	if o.userSpecifiedKeepSuccessful < 0 || o.userSpecifiedKeepFailed < 0 {
+		return errors.New("--keep-successful and --keep-failed must not be negative")
+	}
+
+	return nil
+}
+
+// Run deletes the sync jobs in the namespace that have finished, except for
+// the newest ones within the retention limits. The limits are resolved like
+// the ones of the sync command, --keep-successful and --keep-failed take
+// precedence.
+func (o *CleanupOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadClusterDefaults(clientset, o.ErrOut); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, o.currentNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get namespace %s: %s", o.currentNamespace, err)
+	}
+
+	// the retention limits are resolved, even if the namespace is not
+	// configured for synchronization
+	opts, err := o.resolve(ns)
+	if err != nil && !errors.Is(err, vaultsync.ErrNotConfigured) {
+		return err
+	}
+
+	keep, err := opts.config.Retention()
+	if err != nil {
+		return err
+	}
+
+	runner := &vaultsync.Runner{
+		Clientset: clientset,
+		Retention: keep,
+	}
+
+	deleted, err := runner.Cleanup(ctx, o.currentNamespace)
+	for _, name := range deleted {
+		fmt.Fprintf(o.Out, "job.batch/%s deleted\n", name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(deleted) == 0 {
+		fmt.Fprintf(o.ErrOut, "No finished sync jobs found in %s namespace.\n", o.currentNamespace)
+	}
+
+	return nil
+}
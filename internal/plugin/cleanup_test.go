@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestCmdCleanup returns the cleanup command using clientset.
+func newTestCmdCleanup(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewCleanupOptions(streams)
+	o.clientset = clientset
+
+	return newCmdCleanup(o)
+}
+
+// jobNames returns the sorted names of the jobs in the test namespace.
+func jobNames(t *testing.T, clientset kubernetes.Interface) []string {
+	t.Helper()
+
+	jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	names := []string{}
+	for i := range jobs.Items {
+		names = append(names, jobs.Items[i].Name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestCleanup(t *testing.T) {
+	var tt = []struct {
+		name              string
+		annotations       map[string]string
+		args              []string
+		expectedOut       string
+		expectedRemaining []string
+	}{
+		{
+			"all",
+			nil,
+			[]string{"--keep-failed=0"},
+			"job.batch/vault-sync-4 deleted\njob.batch/vault-sync-3 deleted\njob.batch/vault-sync-2 deleted\njob.batch/vault-sync-1 deleted\n",
+			[]string{"vault-sync-5"},
+		},
+		{
+			"keep",
+			nil,
+			[]string{"--keep-successful=1", "--keep-failed=1"},
+			"job.batch/vault-sync-2 deleted\njob.batch/vault-sync-1 deleted\n",
+			[]string{"vault-sync-3", "vault-sync-4", "vault-sync-5"},
+		},
+		{
+			"defaults",
+			nil,
+			nil,
+			"job.batch/vault-sync-3 deleted\njob.batch/vault-sync-2 deleted\njob.batch/vault-sync-1 deleted\n",
+			[]string{"vault-sync-4", "vault-sync-5"},
+		},
+		{
+			"annotation",
+			map[string]string{vaultsync.AnnotationKeepFailed: "2"},
+			nil,
+			"job.batch/vault-sync-3 deleted\njob.batch/vault-sync-1 deleted\n",
+			[]string{"vault-sync-2", "vault-sync-4", "vault-sync-5"},
+		},
+		{
+			"flag overrides annotation",
+			map[string]string{vaultsync.AnnotationKeepFailed: "2"},
+			[]string{"--keep-failed=0"},
+			"job.batch/vault-sync-4 deleted\njob.batch/vault-sync-3 deleted\njob.batch/vault-sync-2 deleted\njob.batch/vault-sync-1 deleted\n",
+			[]string{"vault-sync-5"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace, Annotations: tc.annotations}},
+				syncJob("vault-sync-1", 5*time.Minute, vaultsync.StatusSucceeded),
+				syncJob("vault-sync-2", 4*time.Minute, vaultsync.StatusFailed),
+				syncJob("vault-sync-3", 3*time.Minute, vaultsync.StatusSucceeded),
+				syncJob("vault-sync-4", 2*time.Minute, vaultsync.StatusFailed),
+				syncJob("vault-sync-5", time.Minute, vaultsync.StatusRunning),
+			)
+
+			out, err := runCommand(t, newTestCmdCleanup, clientset, tc.args...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedOut, out)
+			assert.Equal(t, tc.expectedRemaining, jobNames(t, clientset))
+		})
+	}
+}
+
+func TestCleanupValidate(t *testing.T) {
+	_, err := runCommand(t, newTestCmdCleanup, newFakeClientset(), "--keep-failed=-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must not be negative")
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// dfltClusterDefaults is the config map with the cluster wide settings.
+const dfltClusterDefaults = "kube-system/vault-sync-defaults"
+
+// loadClusterDefaults reads the cluster wide settings from the config map
+// given with --defaults-configmap. The keys of the config map are the names
+// of the namespace annotations without prefix, e.g. addr or trust-secret.
+// A missing config map is ignored. If it can not be read for another
+// reason, a warning is written to warnings.
+func (o *jobOptions) loadClusterDefaults(clientset kubernetes.Interface, warnings io.Writer) error {
+	if o.userSpecifiedClusterDefaults == "" {
+		return nil
+	}
+
+	parts := strings.Split(o.userSpecifiedClusterDefaults, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid config map %q: must be <namespace>/<name>", o.userSpecifiedClusterDefaults)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	cm, err := clientset.CoreV1().ConfigMaps(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		fmt.Fprintf(warnings, "Warning: could not read cluster defaults from config map %s: %s\n", o.userSpecifiedClusterDefaults, err)
+		return nil
+	}
+
+	o.clusterDefaults = cm.Data
+
+	return nil
+}
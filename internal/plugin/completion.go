@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	shellKubectl = "kubectl"
+
+	// kubectlCompletionScript is the script kubectl (>= 1.26) runs to
+	// complete the arguments of the plugin. It has to be installed as
+	// kubectl_complete-vault_sync in the PATH.
+	kubectlCompletionScript = `#!/usr/bin/env sh
+
+# Provides the completion of 'kubectl vault_sync'.
+kubectl-vault_sync __complete "$@"
+`
+)
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell", shellKubectl}
+
+var completionExample = `
+	# load the completion of %[1]s-%[2]s in the current bash shell
+	source <(%[1]s-%[2]s completion bash)
+
+	# complete 'kubectl %[2]s' (requires kubectl 1.26 or newer)
+	%[1]s-%[2]s completion kubectl > ~/bin/kubectl_complete-%[2]s
+	chmod +x ~/bin/kubectl_complete-%[2]s
+`
+
+// NewCmdCompletion provides a cobra command printing the shell completion
+// scripts.
+func NewCmdCompletion(streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   fmt.Sprintf("completion [%s]", strings.Join(completionShells, "|")),
+		Short: "Print the shell completion script",
+		Long: "Print the completion script for the given shell. The shell kubectl prints the script that kubectl " +
+			"(1.26 or newer) runs to complete 'kubectl " + Name + "', it has to be installed as executable " +
+			"kubectl_complete-" + Name + " in the PATH.",
+		Example:               fmt.Sprintf(completionExample, "kubectl", Name),
+		Args:                  cobra.ExactValidArgs(1),
+		ValidArgs:             completionShells,
+		DisableFlagsInUseLine: true,
+		SilenceUsage:          true,
+		RunE: func(c *cobra.Command, args []string) error {
+			// the standalone binary is called kubectl-vault_sync
+			root := c.Root()
+			root.Use = "kubectl-" + Name
+
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(streams.Out, true)
+			case "zsh":
+				return root.GenZshCompletion(streams.Out)
+			case "fish":
+				return root.GenFishCompletion(streams.Out, true)
+			case "powershell":
+				return root.GenPowerShellCompletionWithDesc(streams.Out)
+			}
+
+			_, err := io.WriteString(streams.Out, kubectlCompletionScript)
+
+			return err
+		},
+	}
+
+	return cmd
+}
+
+// registerNamespaceCompletion completes the --namespace flag of cmd with
+// the namespaces that have a secrets path annotation.
+func registerNamespaceCompletion(cmd *cobra.Command, clientset clientsetFactory) {
+	_ = cmd.RegisterFlagCompletionFunc("namespace", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeNamespaces(clientset, toComplete), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// completeNamespaces returns the namespaces with a secrets path annotation
+// starting with toComplete. Errors are ignored, since there is no way to
+// report them during completion.
+func completeNamespaces(clientset clientsetFactory, toComplete string) []string {
+	c, err := clientset()
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	list, err := c.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+
+	for _, ns := range list.Items {
+		if _, ok := ns.GetAnnotations()[vaultsync.AnnotationSecretsPath]; ok && strings.HasPrefix(ns.Name, toComplete) {
+			names = append(names, ns.Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// completeSecrets completes the secret argument of the sync command with
+// the names of the secrets with the namespace's secrets prefix, without the
+// prefix.
+func (o *SyncOptions) completeSecrets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || o.userSpecifiedAllNamespaces || o.userSpecifiedNamespaceSelector != "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	names, err := o.secretNames(toComplete)
+	if err != nil {
+		cobra.CompDebugln(err.Error(), false)
+	}
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// secretNames returns the names of the secrets with the secrets prefix of
+// the current namespace without the prefix, starting with toComplete.
+func (o *SyncOptions) secretNames(toComplete string) ([]string, error) {
+	var err error
+
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := resolveNamespace(o.configFlags, &o.rawConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.loadProfile(o.configFlags, &o.rawConfig); err != nil {
+		return nil, err
+	}
+
+	clientset, err := o.clientset()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.loadClusterDefaults(clientset, io.Discard); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get namespace %s: %s", namespace, err)
+	}
+
+	// a namespace without secrets path can still have a prefix
+	opts, _ := o.resolve(ns)
+
+	prefix := opts.config.SecretsPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "-") {
+		prefix += "-"
+	}
+
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list secrets: %s", err)
+	}
+
+	names := []string{}
+
+	for _, s := range secrets.Items {
+		if !strings.HasPrefix(s.Name, prefix) {
+			continue
+		}
+
+		if name := strings.TrimPrefix(s.Name, prefix); strings.HasPrefix(name, toComplete) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
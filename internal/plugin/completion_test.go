@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/info"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+const cobraCompleteCmd = "__complete"
+
+// complete runs the completion of the sync command for args against
+// clientset and returns the completions without the directive.
+func complete(t *testing.T, clientset kubernetes.Interface, args ...string) []string {
+	t.Helper()
+
+	out, err := runCommand(t, newTestCmdSync, clientset, append([]string{cobraCompleteCmd}, args...)...)
+	require.NoError(t, err)
+
+	completions := []string{}
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if !strings.HasPrefix(line, ":") {
+			completions = append(completions, line)
+		}
+	}
+
+	return completions
+}
+
+func TestCompleteSecrets(t *testing.T) {
+	secret := func(name string) *v1.Secret {
+		return &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace}}
+	}
+
+	clientset := newFakeClientset(secret("annotation-web"), secret("annotation-db"), secret("other"))
+
+	assert.Equal(t, []string{"db", "web"}, complete(t, clientset, ""))
+	assert.Equal(t, []string{"web"}, complete(t, clientset, "w"))
+	assert.Equal(t, []string{"other"}, complete(t, clientset, "--vault-secret-prefix=", "o"))
+	assert.Empty(t, complete(t, clientset, "web", ""), "only one secret can be synchronized")
+}
+
+func TestCompleteNamespaces(t *testing.T) {
+	clientset := newFakeClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team", Annotations: map[string]string{vaultsync.AnnotationSecretsPath: "secret/team"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unconfigured"}},
+	)
+
+	assert.Equal(t, []string{"team", testNamespace}, complete(t, clientset, "--namespace", ""))
+	assert.Equal(t, []string{"team"}, complete(t, clientset, "--namespace", "tea"))
+}
+
+func TestCompletionKubectl(t *testing.T) {
+	streams, _, out, _ := genericclioptions.NewTestIOStreams()
+
+	cmd := NewCmdVaultSync(streams, info.New(Name, "", "", ""))
+	cmd.SetArgs([]string{"completion", shellKubectl})
+	require.NoError(t, cmd.Execute())
+
+	script, err := os.ReadFile("../../kubectl_complete-" + Name)
+	require.NoError(t, err)
+	assert.Equal(t, string(script), out.String(), "the released script must match the completion command")
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configDir  = "kubectl-vault_sync"
+	configFile = "config.yaml"
+)
+
+// config is the user configuration file of the plugin. It contains named
+// profiles with settings for namespaces that are not (fully) annotated.
+//
+//	profiles:
+//	  prod-cluster:
+//	    addr: https://vault.example.com
+//	    trust-secret: vault-tls
+type config struct {
+	Profiles map[string]profile `json:"profiles"`
+}
+
+// profile holds the settings of a profile. The keys are the names of the
+// corresponding namespace annotations without prefix.
+type profile = vaultsync.Config
+
+// configPath returns the path of the configuration file:
+// $XDG_CONFIG_HOME/kubectl-vault_sync/config.yaml, where XDG_CONFIG_HOME
+// defaults to ~/.config.
+func configPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not find configuration directory: %s", err)
+		}
+
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, configDir, configFile), nil
+}
+
+// loadConfig reads the configuration file at path. A missing file results
+// in an empty configuration.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+
+	data, err := os.ReadFile(path) // nolint: gosec
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration file: %s", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse configuration file %s: %s", path, err)
+	}
+
+	return cfg, nil
+}
+
+// profile returns the profile with the given name. Without name, the profile
+// named like the current kubeconfig context is used or, if there is none,
+// the profile named like the current cluster. If no profile matches, an
+// empty profile is returned.
+func (c *config) profile(name, context, cluster string) (profile, error) {
+	if name != "" {
+		p, ok := c.Profiles[name]
+		if !ok {
+			return profile{}, fmt.Errorf("profile %s not found", name)
+		}
+
+		return p, nil
+	}
+
+	for _, n := range []string{context, cluster} {
+		if p, ok := c.Profiles[n]; ok && n != "" {
+			return p, nil
+		}
+	}
+
+	return profile{}, nil
+}
+
+// currentContext returns the names of the kubeconfig context and cluster
+// in use, taking the --context and --cluster flags into account.
+func currentContext(configFlags *genericclioptions.ConfigFlags, rawConfig *api.Config) (string, string) {
+	contextName := rawConfig.CurrentContext
+	if configFlags.Context != nil && *configFlags.Context != "" {
+		contextName = *configFlags.Context
+	}
+
+	cluster := ""
+	if c, ok := rawConfig.Contexts[contextName]; ok {
+		cluster = c.Cluster
+	}
+
+	if configFlags.ClusterName != nil && *configFlags.ClusterName != "" {
+		cluster = *configFlags.ClusterName
+	}
+
+	return contextName, cluster
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `profiles:
+  test-context:
+    addr: https://context.vault.io
+  test-cluster:
+    addr: https://cluster.vault.io
+  prod:
+    addr: https://prod.vault.io
+    trust-secret: vault-tls
+`
+
+func TestConfigProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFile)
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0o600))
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+
+	var tt = []struct {
+		name         string
+		profile      string
+		context      string
+		cluster      string
+		expectedAddr string
+		expectedErr  bool
+	}{
+		{"by flag", "prod", "test-context", "test-cluster", "https://prod.vault.io", false},
+		{"by context", "", "test-context", "test-cluster", "https://context.vault.io", false},
+		{"by cluster", "", "other", "test-cluster", "https://cluster.vault.io", false},
+		{"no match", "", "other", "other", "", false},
+		{"unknown profile", "unknown", "test-context", "test-cluster", "", true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := cfg.profile(tc.profile, tc.context, tc.cluster)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedAddr, p.Addr)
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := loadConfig(filepath.Join(dir, "missing.yaml"))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Profiles)
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalid, []byte("profiles:\n  prod:\n    address: https://vault.io\n"), 0o600))
+
+	_, err = loadConfig(invalid)
+	require.Error(t, err)
+}
@@ -0,0 +1,615 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+
+	podSecurityWarnLabel  = "pod-security.kubernetes.io/warn"
+	podSecurityAuditLabel = "pod-security.kubernetes.io/audit"
+
+	setupHint = "run 'kubectl vault_sync setup'"
+)
+
+// imageReference matches an image reference: an optional registry with
+// port, the repository path, an optional tag and an optional digest.
+var imageReference = regexp.MustCompile(`^` +
+	`(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?` +
+	`$`)
+
+// checkResult is the outcome of a single doctor check.
+type checkResult struct {
+	status  string
+	name    string
+	message string
+	// hint tells how to fix a failure or a warning.
+	hint string
+}
+
+func pass(name, message string) checkResult {
+	return checkResult{status: checkPass, name: name, message: message}
+}
+
+func warn(name, message, hint string) checkResult {
+	return checkResult{status: checkWarn, name: name, message: message, hint: hint}
+}
+
+func fail(name, message, hint string) checkResult {
+	return checkResult{status: checkFail, name: name, message: message, hint: hint}
+}
+
+// DoctorOptions provides information required to check whether a namespace
+// is ready for vault synchronization.
+type DoctorOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	jobOptions
+
+	rawConfig api.Config
+
+	genericclioptions.IOStreams
+}
+
+// NewDoctorOptions provides an instance of DoctorOptions with default values
+func NewDoctorOptions(streams genericclioptions.IOStreams) *DoctorOptions {
+	o := &DoctorOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdDoctor provides a cobra command wrapping DoctorOptions
+func NewCmdDoctor(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdDoctor(NewDoctorOptions(streams))
+}
+
+// newCmdDoctor provides a cobra command wrapping o.
+func newCmdDoctor(o *DoctorOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the prerequisites for vault synchronization in a namespace",
+		Long: "Check the prerequisites for vault synchronization in a namespace: the settings, the images, the service account, " +
+			"the trust secret, the permissions, the pod security admission labels and the resource quotas. " +
+			"The command fails if at least one check fails.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	o.jobOptions.addFlags(cmd.Flags())
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for running the checks
+func (o *DoctorOptions) Complete(cmd *cobra.Command, args []string) error {
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadProfile(o.configFlags, &o.rawConfig); err != nil {
+		return err
+	}
+
+	return o.loadPatchFile()
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *DoctorOptions) Validate() error {
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run checks the namespace and prints a report. It returns an error if
+// at least one check failed.
+func (o *DoctorOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadClusterDefaults(clientset, o.ErrOut); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, o.currentNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get namespace %s: %s", o.currentNamespace, err)
+	}
+
+	results := o.check(ctx, clientset, ns)
+
+	w := printers.GetNewTabWriter(o.Out)
+	failed := 0
+
+	fmt.Fprintln(w, "STATUS\tCHECK\tMESSAGE\tHINT")
+
+	for _, r := range results {
+		if r.status == checkFail {
+			failed++
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.status, r.name, r.message, r.hint)
+	}
+
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+
+	return nil
+}
+
+// check runs all checks for namespace ns.
+func (o *DoctorOptions) check(ctx context.Context, clientset kubernetes.Interface, ns *v1.Namespace) []checkResult {
+	opts, err := o.resolve(ns)
+	results := checkSettings(opts)
+
+	if errors.Is(err, vaultsync.ErrNotConfigured) {
+		return append(results, fail("job", "not checked", "set the missing settings first"))
+	}
+
+	results = append(results,
+		checkAddr(opts.config.Addr),
+		checkImage("sync-image", opts.config.SyncImage),
+	)
+
+	c := opts.jobConfig()
+
+	results = append(results,
+		checkImage("auth-image", c.AuthenticatorImage()),
+		checkServiceAccount(ctx, clientset, ns.Name),
+		checkTrustSecret(ctx, clientset, ns.Name, opts.config.TrustSecret),
+	)
+
+	if opts.config.AuthMethod == vaultsync.AuthAppRole {
+		results = append(results, checkAppRoleSecret(ctx, clientset, ns.Name, opts.config.AppRoleSecret))
+	}
+
+	results = append(results, checkAccess(ctx, clientset, ns.Name)...)
+	results = append(results, checkServiceAccountAccess(ctx, clientset, ns.Name))
+
+	batchJob, _, err := o.newJob(ctx, clientset, ns, &vaultsync.Builder{})
+	if err != nil {
+		return append(results, fail("job", err.Error(), "fix the setting, see 'kubectl vault_sync explain'"))
+	}
+
+	results = append(results, pass("job", "valid"))
+	results = append(results, checkPodSecurity(ns, batchJob)...)
+
+	return append(results, checkQuotas(ctx, clientset, ns.Name, batchJob)...)
+}
+
+// checkSettings checks that the settings required by the auth method and
+// the job are set.
+func checkSettings(opts *jobOptions) []checkResult {
+	results := []checkResult{}
+
+	for _, s := range opts.settings() {
+		required := s.Required ||
+			(s.Name == "role" && opts.config.AuthMethod != vaultsync.AuthAppRole) ||
+			(s.Name == "approle-secret" && opts.config.AuthMethod == vaultsync.AuthAppRole)
+		if !required {
+			continue
+		}
+
+		name := "setting " + s.Name
+
+		if *s.Value == "" {
+			results = append(results, fail(name, "not set", fmt.Sprintf("set annotation %s or flag --%s", s.Annotation, settingFlags[s.Name])))
+			continue
+		}
+
+		results = append(results, pass(name, fmt.Sprintf("%s (%s)", *s.Value, opts.sources[s.Name])))
+	}
+
+	return results
+}
+
+// checkAddr checks that addr is an absolute http(s) URL.
+func checkAddr(addr string) checkResult {
+	name := "vault address"
+	hint := "use the URL of the vault server, e.g. https://vault.example.com:8200"
+
+	u, err := url.Parse(addr)
+
+	switch {
+	case err != nil:
+		return fail(name, err.Error(), hint)
+	case u.Host == "" || (u.Scheme != "https" && u.Scheme != "http"):
+		return fail(name, fmt.Sprintf("%q is not a http(s) URL", addr), hint)
+	case u.Scheme == "http":
+		return warn(name, addr+" is not encrypted", "use https")
+	}
+
+	return pass(name, "valid URL")
+}
+
+// checkImage checks the syntax of an image reference and warns about
+// images that are not pinned.
+func checkImage(setting, image string) checkResult {
+	name := "image " + setting
+
+	if !imageReference.MatchString(image) {
+		return fail(name, fmt.Sprintf("invalid image reference %q", image), "use [registry/]repository[:tag][@digest]")
+	}
+
+	if !strings.Contains(image, "@") && (!hasTag(image) || strings.HasSuffix(image, ":latest")) {
+		return warn(name, image+" is not pinned", "use a fixed tag or a digest")
+	}
+
+	return pass(name, image)
+}
+
+// hasTag returns true if image has a tag. A colon before the last slash
+// separates the registry port.
+func hasTag(image string) bool {
+	return strings.Contains(image[strings.LastIndex(image, "/")+1:], ":")
+}
+
+func checkServiceAccount(ctx context.Context, clientset kubernetes.Interface, namespace string) checkResult {
+	name := "serviceaccount " + job.ServiceAccountName
+
+	_, err := clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, job.ServiceAccountName, metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return fail(name, "not found", setupHint)
+	case err != nil:
+		return fail(name, err.Error(), "")
+	}
+
+	return pass(name, "exists")
+}
+
+func checkTrustSecret(ctx context.Context, clientset kubernetes.Interface, namespace, secretName string) checkResult {
+	name := "trust secret"
+
+	if secretName == "" {
+		return pass(name, "not configured")
+	}
+
+	name += " " + secretName
+	hint := setupHint + " with --ca-file"
+
+	s, err := clientset.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return fail(name, "not found", hint)
+	case err != nil:
+		return fail(name, err.Error(), "")
+	}
+
+	ca, ok := s.Data[job.TruststoreKey]
+	if !ok {
+		return fail(name, fmt.Sprintf("key %s not found", job.TruststoreKey), hint)
+	}
+
+	if err := validateCA(ca); err != nil {
+		return fail(name, fmt.Sprintf("key %s is invalid: %s", job.TruststoreKey, err), hint)
+	}
+
+	return pass(name, "exists")
+}
+
+func checkAppRoleSecret(ctx context.Context, clientset kubernetes.Interface, namespace, secretName string) checkResult {
+	name := "approle secret " + secretName
+	hint := fmt.Sprintf("create the secret with the keys %s and %s", job.AppRoleRoleIDKey, job.AppRoleSecretIDKey)
+
+	s, err := clientset.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return fail(name, "not found", hint)
+	case err != nil:
+		return fail(name, err.Error(), "")
+	}
+
+	for _, key := range []string{job.AppRoleRoleIDKey, job.AppRoleSecretIDKey} {
+		if _, ok := s.Data[key]; !ok {
+			return fail(name, fmt.Sprintf("key %s not found", key), hint)
+		}
+	}
+
+	return pass(name, "exists")
+}
+
+// accessCheck is a permission of the user. A missing permission that is
+// not required results in a warning.
+type accessCheck struct {
+	verb        string
+	group       string
+	resource    string
+	subresource string
+	required    bool
+	purpose     string
+}
+
+var accessChecks = []accessCheck{
+	{"create", "batch", "jobs", "", true, "create sync jobs"},
+	{"delete", "batch", "jobs", "", true, "delete finished sync jobs"},
+	{"watch", "batch", "jobs", "", false, "wait for sync jobs"},
+	{"create", "coordination.k8s.io", "leases", "", true, "lock the namespace"},
+	{"list", "", "pods", "", false, "wait for the pods of sync jobs"},
+	{"watch", "", "pods", "", false, "wait for the pods of sync jobs"},
+	{"get", "", "pods", "log", false, "show the logs of sync jobs"},
+	{"list", "", "secrets", "", false, "list the synchronized secrets"},
+	{"create", "", "events", "", false, "record sync events"},
+}
+
+// checkAccess checks the permissions of the user with self subject access
+// reviews.
+func checkAccess(ctx context.Context, clientset kubernetes.Interface, namespace string) []checkResult {
+	results := []checkResult{}
+	hint := "ask a namespace admin for the permission"
+
+	for _, a := range accessChecks {
+		resourceName := a.resource
+		if a.subresource != "" {
+			resourceName += "/" + a.subresource
+		}
+
+		name := fmt.Sprintf("permission %s %s", a.verb, resourceName)
+
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        a.verb,
+					Group:       a.group,
+					Resource:    a.resource,
+					Subresource: a.subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+
+		switch {
+		case err != nil:
+			results = append(results, warn(name, fmt.Sprintf("could not be checked: %s", err), ""))
+		case review.Status.Allowed:
+			results = append(results, pass(name, "allowed"))
+		case a.required:
+			results = append(results, fail(name, "denied, required to "+a.purpose, hint))
+		default:
+			results = append(results, warn(name, "denied, required to "+a.purpose, hint))
+		}
+	}
+
+	return results
+}
+
+// checkServiceAccountAccess checks that the service account of the sync job
+// may create and update secrets. If the user may not review the access of
+// others, a warning is returned.
+func checkServiceAccountAccess(ctx context.Context, clientset kubernetes.Interface, namespace string) checkResult {
+	name := fmt.Sprintf("permission of serviceaccount %s", job.ServiceAccountName)
+
+	for _, verb := range []string{"create", "update"} {
+		review, err := clientset.AuthorizationV1().LocalSubjectAccessReviews(namespace).Create(ctx, &authorizationv1.LocalSubjectAccessReview{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   fmt.Sprintf("system:serviceaccount:%s:%s", namespace, job.ServiceAccountName),
+				Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Resource:  "secrets",
+				},
+			},
+		}, metav1.CreateOptions{})
+
+		switch {
+		case err != nil:
+			return warn(name, fmt.Sprintf("could not be checked: %s", err), "")
+		case !review.Status.Allowed:
+			return fail(name, verb+" secrets denied", setupHint)
+		}
+	}
+
+	return pass(name, "may create and update secrets")
+}
+
+// checkPodSecurity checks the pod of batchJob against the pod security
+// admission labels of namespace ns.
+func checkPodSecurity(ns *v1.Namespace, batchJob *batchv1.Job) []checkResult {
+	results := []checkResult{}
+	violations := restrictedViolations(&batchJob.Spec.Template.Spec)
+	hint := fmt.Sprintf("remove --hardened=false or the annotation %s", vaultsync.AnnotationHardened)
+
+	for _, label := range []string{podSecurityEnforceLabel, podSecurityWarnLabel, podSecurityAuditLabel} {
+		level, ok := ns.Labels[label]
+		if !ok {
+			continue
+		}
+
+		name := "pod security " + label[strings.LastIndex(label, "/")+1:]
+
+		switch {
+		case level != podSecurityRestricted || len(violations) == 0:
+			results = append(results, pass(name, level))
+		case label == podSecurityEnforceLabel:
+			results = append(results, fail(name, "the pod would be rejected: "+strings.Join(violations, ", "), hint))
+		default:
+			results = append(results, warn(name, "the pod violates the restricted standard: "+strings.Join(violations, ", "), hint))
+		}
+	}
+
+	if len(results) == 0 {
+		results = append(results, pass("pod security", "no labels"))
+	}
+
+	return results
+}
+
+// quotaResource is the pod resource a quota resource counts.
+type quotaResource struct {
+	name  v1.ResourceName
+	limit bool
+}
+
+var quotaResources = map[v1.ResourceName]quotaResource{
+	v1.ResourceCPU:            {v1.ResourceCPU, false},
+	v1.ResourceMemory:         {v1.ResourceMemory, false},
+	v1.ResourceRequestsCPU:    {v1.ResourceCPU, false},
+	v1.ResourceRequestsMemory: {v1.ResourceMemory, false},
+	v1.ResourceLimitsCPU:      {v1.ResourceCPU, true},
+	v1.ResourceLimitsMemory:   {v1.ResourceMemory, true},
+}
+
+// checkQuotas checks that the resource quotas of the namespace leave room
+// for the sync job and its pod.
+func checkQuotas(ctx context.Context, clientset kubernetes.Interface, namespace string, batchJob *batchv1.Job) []checkResult {
+	quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return []checkResult{warn("resource quota", fmt.Sprintf("could not be checked: %s", err), "")}
+	}
+
+	if len(quotas.Items) == 0 {
+		return []checkResult{pass("resource quota", "none")}
+	}
+
+	results := []checkResult{}
+	requests, limits := podResources(&batchJob.Spec.Template.Spec)
+
+	for _, q := range quotas.Items {
+		name := "resource quota " + q.Name
+		exceeded := []string{}
+		unset := []string{}
+
+		for res, hard := range q.Status.Hard {
+			var need resource.Quantity
+
+			r, ok := quotaResources[res]
+
+			switch {
+			case res == v1.ResourcePods || res == "count/jobs.batch":
+				need = resource.MustParse("1")
+			case ok && r.limit:
+				need = limits[r.name]
+			case ok:
+				need = requests[r.name]
+			default:
+				continue
+			}
+
+			if need.IsZero() {
+				unset = append(unset, string(res))
+				continue
+			}
+
+			used := q.Status.Used[res]
+			total := used.DeepCopy()
+			total.Add(need)
+
+			if total.Cmp(hard) > 0 {
+				exceeded = append(exceeded, fmt.Sprintf("%s %s of %s used, the job needs %s", res, used.String(), hard.String(), need.String()))
+			}
+		}
+
+		sort.Strings(exceeded)
+		sort.Strings(unset)
+
+		switch {
+		case len(exceeded) > 0:
+			results = append(results, fail(name, strings.Join(exceeded, ", "), "free resources or ask for a higher quota"))
+		case len(unset) > 0:
+			results = append(results, warn(name, "the job does not set "+strings.Join(unset, ", "),
+				"set --requests and --limits, unless a limit range sets defaults"))
+		default:
+			results = append(results, pass(name, "enough headroom"))
+		}
+	}
+
+	return results
+}
+
+// podResources returns the effective requests and limits of a pod: the
+// maximum of any init container and the sum of the containers.
+func podResources(spec *v1.PodSpec) (v1.ResourceList, v1.ResourceList) {
+	requests, limits := v1.ResourceList{}, v1.ResourceList{}
+
+	for _, c := range spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+
+	for _, c := range spec.InitContainers {
+		maxResources(requests, c.Resources.Requests)
+		maxResources(limits, c.Resources.Limits)
+	}
+
+	return requests, limits
+}
+
+func addResources(list, add v1.ResourceList) {
+	for name, q := range add {
+		sum := list[name]
+		sum.Add(q)
+		list[name] = sum
+	}
+}
+
+func maxResources(list, other v1.ResourceList) {
+	for name, q := range other {
+		if current, ok := list[name]; !ok || q.Cmp(current) > 0 {
+			list[name] = q.DeepCopy()
+		}
+	}
+}
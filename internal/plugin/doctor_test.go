@@ -0,0 +1,312 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTestCmdDoctor returns the doctor command using clientset.
+func newTestCmdDoctor(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewDoctorOptions(streams)
+	o.clientset = clientset
+
+	return newCmdDoctor(o)
+}
+
+// checkStatus returns the status of check in the doctor report out.
+func checkStatus(out, check string) string {
+	m := regexp.MustCompile(`(?m)^(\w+)\s+` + regexp.QuoteMeta(check) + `\s`).FindStringSubmatch(out)
+	if m == nil {
+		return ""
+	}
+
+	return m[1]
+}
+
+// allowAccess lets the fake clientset answer access reviews. The reviews
+// with a verb and resource in denied are not allowed.
+func allowAccess(clientset *k8stesting.Fake, denied ...string) {
+	isDenied := func(attributes *authorizationv1.ResourceAttributes) bool {
+		resourceName := attributes.Resource
+		if attributes.Subresource != "" {
+			resourceName += "/" + attributes.Subresource
+		}
+
+		for _, d := range denied {
+			if d == attributes.Verb+" "+resourceName {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		review := a.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = !isDenied(review.Spec.ResourceAttributes)
+
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "localsubjectaccessreviews", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		review := a.(k8stesting.CreateAction).GetObject().(*authorizationv1.LocalSubjectAccessReview)
+		review.Status.Allowed = !isDenied(review.Spec.ResourceAttributes)
+
+		return true, review, nil
+	})
+}
+
+func TestDoctor(t *testing.T) {
+	ca, err := os.ReadFile(writeCA(t))
+	require.NoError(t, err)
+
+	serviceAccount := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: job.ServiceAccountName, Namespace: testNamespace},
+	}
+	trustSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "annotation-trust", Namespace: testNamespace},
+		Data:       map[string][]byte{job.TruststoreKey: ca},
+	}
+	pinned := []string{"--vault-sync-image=registry.example.com:5000/vault-sync:1.0", "--vault-auth-image=vault-auth@sha256:" + sha256Hex}
+
+	quota := func(hard, used v1.ResourceList) *v1.ResourceQuota {
+		return &v1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: testNamespace},
+			Status:     v1.ResourceQuotaStatus{Hard: hard, Used: used},
+		}
+	}
+
+	var tt = []struct {
+		name           string
+		objects        []runtime.Object
+		denied         []string
+		labels         map[string]string
+		args           []string
+		expectedErr    string
+		expectedChecks map[string]string
+	}{
+		{
+			"ready",
+			[]runtime.Object{serviceAccount, trustSecret},
+			nil,
+			nil,
+			pinned,
+			"",
+			map[string]string{
+				"vault address":                 checkPass,
+				"image sync-image":              checkPass,
+				"image auth-image":              checkPass,
+				"serviceaccount vault-auth":     checkPass,
+				"trust secret annotation-trust": checkPass,
+				"permission create jobs":        checkPass,
+				"permission get pods/log":       checkPass,
+				"permission list pods":          checkPass,
+				"permission watch pods":         checkPass,
+				"permission of serviceaccount":  checkPass,
+				"job":                           checkPass,
+				"pod security":                  checkPass,
+				"resource quota":                checkPass,
+			},
+		},
+		{
+			"missing setting",
+			nil,
+			nil,
+			nil,
+			[]string{"--vault-secretspath="},
+			"checks failed",
+			map[string]string{
+				"setting secrets-path": checkFail,
+				"setting role":         checkPass,
+				"job":                  checkFail,
+			},
+		},
+		{
+			"missing objects",
+			nil,
+			nil,
+			nil,
+			pinned,
+			"2 of",
+			map[string]string{
+				"serviceaccount vault-auth":     checkFail,
+				"trust secret annotation-trust": checkFail,
+			},
+		},
+		{
+			"unpinned and invalid images",
+			[]runtime.Object{serviceAccount, trustSecret},
+			nil,
+			nil,
+			[]string{"--vault-sync-image=vault-sync:latest", "--vault-auth-image=Vault Auth"},
+			"checks failed",
+			map[string]string{
+				"image sync-image": checkWarn,
+				"image auth-image": checkFail,
+			},
+		},
+		{
+			"invalid addr",
+			[]runtime.Object{serviceAccount, trustSecret},
+			nil,
+			nil,
+			append([]string{"--vault-addr=vault.example.com"}, pinned...),
+			"1 of",
+			map[string]string{
+				"vault address": checkFail,
+			},
+		},
+		{
+			"denied permissions",
+			[]runtime.Object{serviceAccount, trustSecret},
+			[]string{"create jobs", "get pods/log", "watch pods", "update secrets"},
+			nil,
+			pinned,
+			"2 of",
+			map[string]string{
+				"permission create jobs":       checkFail,
+				"permission delete jobs":       checkPass,
+				"permission get pods/log":      checkWarn,
+				"permission list pods":         checkPass,
+				"permission watch pods":        checkWarn,
+				"permission of serviceaccount": checkFail,
+			},
+		},
+		{
+			"pod security",
+			[]runtime.Object{serviceAccount, trustSecret},
+			nil,
+			map[string]string{podSecurityEnforceLabel: podSecurityRestricted, podSecurityWarnLabel: podSecurityRestricted, podSecurityAuditLabel: "baseline"},
+			append([]string{"--hardened=false"}, pinned...),
+			"1 of",
+			map[string]string{
+				"pod security enforce": checkFail,
+				"pod security warn":    checkWarn,
+				"pod security audit":   checkPass,
+			},
+		},
+		{
+			"quota exceeded",
+			[]runtime.Object{serviceAccount, trustSecret, quota(
+				v1.ResourceList{v1.ResourcePods: resource.MustParse("10"), v1.ResourceRequestsMemory: resource.MustParse("1Gi")},
+				v1.ResourceList{v1.ResourcePods: resource.MustParse("2"), v1.ResourceRequestsMemory: resource.MustParse("1000Mi")},
+			)},
+			nil,
+			nil,
+			append([]string{"--requests=memory=64Mi"}, pinned...),
+			"1 of",
+			map[string]string{
+				"resource quota quota": checkFail,
+			},
+		},
+		{
+			"quota without requests",
+			[]runtime.Object{serviceAccount, trustSecret, quota(
+				v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse("1")},
+				v1.ResourceList{},
+			)},
+			nil,
+			nil,
+			pinned,
+			"",
+			map[string]string{
+				"resource quota quota": checkWarn,
+			},
+		},
+		{
+			"approle secret",
+			[]runtime.Object{serviceAccount, trustSecret, &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "approle", Namespace: testNamespace},
+				Data:       map[string][]byte{job.AppRoleRoleIDKey: []byte("role-id")},
+			}},
+			nil,
+			nil,
+			append([]string{"--vault-auth-method=approle", "--vault-approle-secret=approle"}, pinned...),
+			"1 of",
+			map[string]string{
+				"setting approle-secret": checkPass,
+				"approle secret approle": checkFail,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset(tc.objects...)
+			allowAccess(&clientset.Fake, tc.denied...)
+
+			ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), testNamespace, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			ns.Labels = tc.labels
+			require.NoError(t, clientset.Tracker().Update(v1.SchemeGroupVersion.WithResource("namespaces"), ns, ""))
+
+			out, err := runCommand(t, newTestCmdDoctor, clientset, tc.args...)
+			if tc.expectedErr != "" {
+				require.Error(t, err, out)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			} else {
+				require.NoError(t, err, out)
+			}
+
+			for check, status := range tc.expectedChecks {
+				assert.Equal(t, status, checkStatus(out, check), "check %s\n%s", check, out)
+			}
+		})
+	}
+}
+
+const sha256Hex = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestCheckImage(t *testing.T) {
+	var tt = []struct {
+		image    string
+		expected string
+	}{
+		{"postfinance/vault-sync:1.0.0", checkPass},
+		{"registry.example.com:5000/team/vault-sync:v1", checkPass},
+		{"vault-sync@sha256:" + sha256Hex, checkPass},
+		{"registry.example.com:5000/vault-sync", checkWarn},
+		{"vault-sync:latest", checkWarn},
+		{"Vault-Sync:1.0", checkFail},
+		{"vault-sync:", checkFail},
+		{"", checkFail},
+	}
+
+	for _, tc := range tt {
+		assert.Equal(t, tc.expected, checkImage("sync-image", tc.image).status, tc.image)
+	}
+}
+
+func TestPodResources(t *testing.T) {
+	spec := &v1.PodSpec{
+		InitContainers: []v1.Container{
+			{Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("256Mi")}}},
+		},
+		Containers: []v1.Container{
+			{Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("64Mi"), v1.ResourceCPU: resource.MustParse("100m")}}},
+			{Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("64Mi"), v1.ResourceCPU: resource.MustParse("100m")}}},
+		},
+	}
+
+	requests, limits := podResources(spec)
+
+	assert.Equal(t, "256Mi", requests.Memory().String(), "the init container needs more memory than the containers")
+	assert.Equal(t, "200m", requests.Cpu().String())
+	assert.Empty(t, limits)
+}
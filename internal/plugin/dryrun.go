@@ -0,0 +1,44 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	dryRunNone   = "none"
+	dryRunClient = "client"
+	dryRunServer = "server"
+)
+
+// serverDryRun submits batchJob and a pod built from the job's pod template
+// to the API server without persisting them. Submitting the pod runs the
+// admission checks that only apply to pods, e.g. the service account
+// admission and the pod security enforcement.
+func serverDryRun(ctx context.Context, clientset kubernetes.Interface, namespace string, batchJob *batchv1.Job) (*batchv1.Job, error) {
+	opts := metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}
+
+	created, err := clientset.BatchV1().Jobs(namespace).Create(ctx, batchJob, opts)
+	if err != nil {
+		return nil, fmt.Errorf("server dry run of batch job %s failed: %s", batchJob.Name, err)
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: batchJob.Name + "-",
+			Labels:       batchJob.Spec.Template.Labels,
+		},
+		Spec: *batchJob.Spec.Template.Spec.DeepCopy(),
+	}
+
+	if _, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, opts); err != nil {
+		return nil, fmt.Errorf("server dry run of the pod of batch job %s failed: %s", batchJob.Name, err)
+	}
+
+	return created, nil
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// createOptionsClientset is a fake clientset that records the options used
+// to create jobs and pods by resource, since the actions of the fake
+// clientset do not contain them.
+type createOptionsClientset struct {
+	*fake.Clientset
+	options map[string][]metav1.CreateOptions
+}
+
+func (c createOptionsClientset) BatchV1() batchv1client.BatchV1Interface {
+	return createOptionsBatchV1{c.Clientset.BatchV1(), c.options}
+}
+
+func (c createOptionsClientset) CoreV1() corev1.CoreV1Interface {
+	return createOptionsCoreV1{c.Clientset.CoreV1(), c.options}
+}
+
+type createOptionsBatchV1 struct {
+	batchv1client.BatchV1Interface
+	options map[string][]metav1.CreateOptions
+}
+
+func (c createOptionsBatchV1) Jobs(namespace string) batchv1client.JobInterface {
+	return createOptionsJobs{c.BatchV1Interface.Jobs(namespace), c.options}
+}
+
+type createOptionsJobs struct {
+	batchv1client.JobInterface
+	options map[string][]metav1.CreateOptions
+}
+
+func (j createOptionsJobs) Create(ctx context.Context, job *batchv1.Job, opts metav1.CreateOptions) (*batchv1.Job, error) {
+	j.options["jobs"] = append(j.options["jobs"], opts)
+	return j.JobInterface.Create(ctx, job, opts)
+}
+
+type createOptionsCoreV1 struct {
+	corev1.CoreV1Interface
+	options map[string][]metav1.CreateOptions
+}
+
+func (c createOptionsCoreV1) Pods(namespace string) corev1.PodInterface {
+	return createOptionsPods{c.CoreV1Interface.Pods(namespace), c.options}
+}
+
+type createOptionsPods struct {
+	corev1.PodInterface
+	options map[string][]metav1.CreateOptions
+}
+
+func (p createOptionsPods) Create(ctx context.Context, pod *v1.Pod, opts metav1.CreateOptions) (*v1.Pod, error) {
+	p.options["pods"] = append(p.options["pods"], opts)
+	return p.PodInterface.Create(ctx, pod, opts)
+}
+
+func TestSyncDryRunClient(t *testing.T) {
+	clientset := newFakeClientset()
+
+	out, err := runCommand(t, newTestCmdSync, clientset, "--dry-run=client", "-o", "name")
+	require.NoError(t, err)
+	assert.Regexp(t, `^job.batch/vault-sync-\S+\n$`, out)
+
+	for _, a := range clientset.Actions() {
+		assert.NotEqual(t, "create", a.GetVerb(), "a client dry run must not create %s", a.GetResource().Resource)
+	}
+}
+
+func TestSyncDryRunServer(t *testing.T) {
+	clientset := createOptionsClientset{newFakeClientset(), map[string][]metav1.CreateOptions{}}
+
+	out, err := runCommand(t, newTestCmdSync, clientset, "--dry-run=server", "-o", "name")
+	require.NoError(t, err)
+	assert.Regexp(t, `^job.batch/vault-sync-\S+\n$`, out)
+
+	dryRun := []metav1.CreateOptions{{DryRun: []string{metav1.DryRunAll}}}
+	assert.Equal(t, dryRun, clientset.options["jobs"], "the job must be submitted with dry run")
+	assert.Equal(t, dryRun, clientset.options["pods"], "the pod must be submitted with dry run")
+
+	for _, a := range clientset.Actions() {
+		assert.NotEqual(t, "delete", a.GetVerb(), "a server dry run must not delete %s", a.GetResource().Resource)
+	}
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	lastSyncTimeAnnotation   = "sync.vault.postfinance.ch/last-sync-time"
+	lastSyncStatusAnnotation = "sync.vault.postfinance.ch/last-sync-status"
+	lastSyncJobAnnotation    = "sync.vault.postfinance.ch/last-sync-job"
+	lastSyncByAnnotation     = "sync.vault.postfinance.ch/last-sync-by"
+
+	eventReasonStarted   = "SyncStarted"
+	eventReasonSucceeded = "SyncSucceeded"
+	eventReasonFailed    = "SyncFailed"
+
+	eventComponent = "kubectl-vault_sync"
+)
+
+// recordSync records the state of the synchronization r of namespace ns as
+// event on the namespace and in its last sync annotations. Recording is
+// skipped silently, if the user is not allowed to create events or to patch
+// the namespace, since recording is enabled by default. Other errors are
+// printed as warnings.
+func (o *SyncOptions) recordSync(clientset kubernetes.Interface, ns *v1.Namespace, r *SyncResult) {
+	if !o.userSpecifiedRecord {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	now := time.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", ns.Name, now.UnixNano()),
+			Namespace: ns.Name,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Name:       ns.Name,
+			UID:        ns.UID,
+		},
+		Source:              v1.EventSource{Component: eventComponent},
+		FirstTimestamp:      metav1.NewTime(now),
+		LastTimestamp:       metav1.NewTime(now),
+		Count:               1,
+		Type:                v1.EventTypeNormal,
+		ReportingController: eventComponent,
+		ReportingInstance:   o.identity,
+	}
+
+	status := r.Status
+
+	switch r.Status {
+	case syncCreated:
+		status = vaultsync.StatusRunning
+		event.Reason = eventReasonStarted
+		event.Message = fmt.Sprintf("Sync job %s for %s started by %s", r.JobName, r.SecretPath, o.identity)
+	case vaultsync.StatusSucceeded:
+		event.Reason = eventReasonSucceeded
+		event.Message = fmt.Sprintf("Sync job %s for %s succeeded after %s", r.JobName, r.SecretPath, r.Duration)
+	default:
+		event.Type = v1.EventTypeWarning
+		event.Reason = eventReasonFailed
+		event.Message = fmt.Sprintf("Sync job %s for %s failed: %s", r.JobName, r.SecretPath, r.Reason)
+
+		if r.JobName == "" {
+			event.Message = fmt.Sprintf("Sync failed before the sync job was created by %s: %s", o.identity, r.Reason)
+		}
+	}
+
+	if _, err := clientset.CoreV1().Events(ns.Name).Create(ctx, event, metav1.CreateOptions{}); err != nil && !apierrors.IsForbidden(err) {
+		fmt.Fprintf(o.ErrOut, "Warning: could not record event on namespace %s: %s\n", ns.Name, err)
+	}
+
+	annotations := map[string]interface{}{
+		lastSyncTimeAnnotation:   now.UTC().Format(time.RFC3339),
+		lastSyncStatusAnnotation: status,
+		lastSyncJobAnnotation:    r.JobName,
+		lastSyncByAnnotation:     o.identity,
+	}
+
+	// a sync that failed before its job was created has no job
+	if r.JobName == "" {
+		annotations[lastSyncJobAnnotation] = nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(o.ErrOut, "Warning: could not record sync status on namespace %s: %s\n", ns.Name, err)
+		return
+	}
+
+	_, err = clientset.CoreV1().Namespaces().Patch(ctx, ns.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsForbidden(err) {
+		fmt.Fprintf(o.ErrOut, "Warning: could not record sync status on namespace %s: %s\n", ns.Name, err)
+	}
+}
+
+// recordFailure records the synchronization r of namespace ns as failed
+// with err, e.g. if the sync job could not be created.
+func (o *SyncOptions) recordFailure(clientset kubernetes.Interface, ns *v1.Namespace, r *SyncResult, err error) {
+	r.fail(err)
+	o.recordSync(clientset, ns, r)
+}
@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+var explainExample = `
+	# show the settings of the sync job and where they come from
+	%[1]s %[2]s explain
+
+	# show the settings with another profile
+	%[1]s %[2]s explain --profile prod
+`
+
+// ExplainOptions provides information required to show the resolved
+// settings of the sync job.
+type ExplainOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	jobOptions
+
+	rawConfig api.Config
+
+	genericclioptions.IOStreams
+}
+
+// NewExplainOptions provides an instance of ExplainOptions with default values
+func NewExplainOptions(streams genericclioptions.IOStreams) *ExplainOptions {
+	o := &ExplainOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdExplain provides a cobra command wrapping ExplainOptions
+func NewCmdExplain(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdExplain(NewExplainOptions(streams))
+}
+
+// newCmdExplain provides a cobra command wrapping o.
+func newCmdExplain(o *ExplainOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "explain",
+		Short:        "Show the settings of the sync job and whether they come from a flag, an annotation, the cluster defaults, the configuration file or the built-in defaults",
+		Example:      fmt.Sprintf(explainExample, "kubectl", Name),
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	o.jobOptions.addFlags(cmd.Flags())
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for resolving the settings
+func (o *ExplainOptions) Complete(cmd *cobra.Command, args []string) error {
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	if err != nil {
+		return err
+	}
+
+	return o.loadProfile(o.configFlags, &o.rawConfig)
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *ExplainOptions) Validate() error {
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run prints each setting with its value and source. Settings that are
+// missing are printed as well, before the error is returned.
+func (o *ExplainOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadClusterDefaults(clientset, o.ErrOut); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, o.currentNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get namespace %s: %s", o.currentNamespace, err)
+	}
+
+	opts, err := o.resolve(ns)
+	if err != nil && !errors.Is(err, vaultsync.ErrNotConfigured) {
+		return err
+	}
+
+	w := printers.GetNewTabWriter(o.Out)
+
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+
+	for _, s := range opts.settings() {
+		// multi-line values like a YAML affinity are printed on one line
+		value := strings.Join(strings.Fields(*s.Value), " ")
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, valueOrNone(value), opts.sources[s.Name])
+	}
+
+	if flushErr := w.Flush(); flushErr != nil {
+		return flushErr
+	}
+
+	return err
+}
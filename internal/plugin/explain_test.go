@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExplain(t *testing.T) {
+	kubeconfig := setupTestConfig(t)
+
+	clientset := fake.NewSimpleClientset(
+		&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: testNamespace,
+				Annotations: map[string]string{
+					vaultsync.AnnotationSecretsPath: "secret/annotation",
+					vaultsync.AnnotationAddr:        "https://annotation.vault.io",
+				},
+			},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "vault-sync-defaults",
+				Namespace: "kube-system",
+			},
+			Data: map[string]string{
+				"addr":         "https://cluster.vault.io",
+				"trust-secret": "vault-tls",
+			},
+		},
+	)
+
+	streams, _, out, _ := genericclioptions.NewTestIOStreams()
+	o := NewExplainOptions(streams)
+	o.clientset = func() (kubernetes.Interface, error) {
+		return clientset, nil
+	}
+
+	cmd := newCmdExplain(o)
+	cmd.SilenceErrors = true
+	cmd.SetArgs([]string{"--kubeconfig", kubeconfig, "--vault-sync-image", "flag-sync-image"})
+
+	// the role is missing, but all settings are explained anyway
+	err := cmd.Execute()
+	require.ErrorIs(t, err, vaultsync.ErrNotConfigured)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, len(o.settings())+1)
+	assert.Equal(t, []string{"SETTING", "VALUE", "SOURCE"}, strings.Fields(lines[0]))
+
+	rows := map[string][]string{}
+	for _, l := range lines[1:] {
+		fields := strings.Fields(l)
+		rows[fields[0]] = fields[1:]
+	}
+
+	expected := map[string][]string{
+		"secrets-path":   {"secret/annotation", sourceAnnotation},
+		"role":           {"<none>", sourceDefault},
+		"addr":           {"https://annotation.vault.io", sourceAnnotation},
+		"mount-path":     {vaultsync.DefaultMountPath, sourceDefault},
+		"secrets-prefix": {vaultsync.DefaultSecretsPrefix, sourceDefault},
+		"sync-image":     {"flag-sync-image", sourceFlag},
+		"auth-image":     {vaultsync.DefaultAuthImage, sourceDefault},
+		"trust-secret":   {"vault-tls", sourceClusterDefaults},
+		"requests":       {"<none>", sourceDefault},
+	}
+
+	for name, row := range expected {
+		assert.Equal(t, row, rows[name], name)
+	}
+}
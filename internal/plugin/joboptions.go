@@ -0,0 +1,258 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/pflag"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// jobOptions holds the user specified settings of the generated sync job.
+// They are shared by all commands that build a sync job.
+type jobOptions struct {
+	// config holds the settings given by flag or their defaults.
+	config vaultsync.Config
+
+	userSpecifiedPatchFile       string
+	userSpecifiedProfile         string
+	userSpecifiedClusterDefaults string
+
+	// clusterDefaults provides the settings that are neither specified by
+	// the user nor by a namespace annotation.
+	clusterDefaults map[string]string
+	// profile provides the settings that are not specified otherwise.
+	profile profile
+	// filePatch is the content of the patch file.
+	filePatch []byte
+
+	// flags is used to find out which settings are specified by the user.
+	flags *pflag.FlagSet
+	// sources records where the value of each setting came from.
+	sources map[string]string
+}
+
+const (
+	sourceFlag            = "flag"
+	sourceAnnotation      = vaultsync.SourceAnnotation
+	sourceClusterDefaults = "configmap"
+	sourceConfig          = "config"
+	sourceDefault         = vaultsync.SourceDefault
+)
+
+// settingFlags are the flags of the settings by name.
+var settingFlags = map[string]string{
+	"secrets-path":         "vault-secretspath",
+	"role":                 "vault-role",
+	"addr":                 "vault-addr",
+	"mount-path":           "vault-mountpath",
+	"secrets-prefix":       "vault-secret-prefix",
+	"sync-image":           "vault-sync-image",
+	"auth-image":           "vault-auth-image",
+	"trust-secret":         "vault-trust-secret",
+	"vault-namespace":      "vault-namespace",
+	"vault-auth-namespace": "vault-auth-namespace",
+	"auth-method":          "vault-auth-method",
+	"jwt-audience":         "vault-jwt-audience",
+	"approle-secret":       "vault-approle-secret",
+	"requests":             "requests",
+	"limits":               "limits",
+	"node-selector":        "node-selector",
+	"tolerations":          "tolerations",
+	"affinity":             "affinity",
+	"priority-class-name":  "priority-class-name",
+	"active-deadline":      "active-deadline",
+	"hardened":             "hardened",
+	"keep-successful":      "keep-successful",
+	"keep-failed":          "keep-failed",
+	"ttl":                  "ttl",
+	"backoff-limit":        "backoff-limit",
+	"patch-configmap":      "patch-configmap",
+}
+
+// settings returns the settings of o. The values point into o.
+func (o *jobOptions) settings() []vaultsync.Setting {
+	return o.config.Settings()
+}
+
+// addFlags adds the flags for the sync job settings.
+func (o *jobOptions) addFlags(flags *pflag.FlagSet) {
+	o.flags = flags
+
+	flags.StringVar(&o.config.Role, "vault-role", "",
+		fmt.Sprintf("Name of the vault role to use for authentication. If not set, value is taken from namespace annotation '%s'.", vaultsync.AnnotationRole))
+	flags.StringVar(&o.config.SecretsPath, "vault-secretspath", "",
+		fmt.Sprintf("Secrets path in vault. If not set, value is taken from namespace annotation '%s'.", vaultsync.AnnotationSecretsPath))
+	flags.StringVar(&o.config.Addr, "vault-addr", "",
+		fmt.Sprintf("The URL the vault server. If not set, value is taken from namespace annotation '%s'.", vaultsync.AnnotationAddr))
+	flags.StringVar(&o.config.TrustSecret, "vault-trust-secret", "",
+		fmt.Sprintf("The kubernetes secret containing a CA certificate 'truststore.pem' to connect to vault. If not set, value is taken from namespace annotation '%s'.", vaultsync.AnnotationTrustSecret))
+	flags.StringVar(&o.config.VaultNamespace, "vault-namespace", "",
+		fmt.Sprintf("The vault enterprise namespace for authentication and the secrets. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationVaultNamespace))
+	flags.StringVar(&o.config.VaultAuthNamespace, "vault-auth-namespace", "",
+		fmt.Sprintf("The vault enterprise namespace for authentication, if it differs from --vault-namespace. Use '%s' for the root namespace. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.RootNamespace, vaultsync.AnnotationVaultAuthNamespace))
+	flags.StringVar(&o.config.AuthMethod, "vault-auth-method", vaultsync.DefaultAuthMethod,
+		fmt.Sprintf("The vault auth method of the init container, one of: %s. If not set, value is taken from namespace annotation '%s' if it exists.", strings.Join(vaultsync.AuthMethods, ", "), vaultsync.AnnotationAuthMethod))
+	flags.StringVar(&o.config.JWTAudience, "vault-jwt-audience", vaultsync.DefaultJWTAudience,
+		fmt.Sprintf("The audience of the projected service account token for the jwt auth method. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationJWTAudience))
+	flags.StringVar(&o.config.AppRoleSecret, "vault-approle-secret", "",
+		fmt.Sprintf("The kubernetes secret with the keys '%s' and '%s' for the approle auth method. If not set, value is taken from namespace annotation '%s' if it exists.", job.AppRoleRoleIDKey, job.AppRoleSecretIDKey, vaultsync.AnnotationAppRoleSecret))
+	flags.StringVar(&o.config.SyncImage, "vault-sync-image", vaultsync.DefaultSyncImage,
+		fmt.Sprintf("The synchronizer image name. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationSyncImage))
+	flags.StringVar(&o.config.AuthImage, "vault-auth-image", vaultsync.DefaultAuthImage,
+		fmt.Sprintf("The authorizer image name. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationAuthImage))
+	flags.StringVar(&o.config.MountPath, "vault-mountpath", vaultsync.DefaultMountPath,
+		fmt.Sprintf("Name of the mount path where the Kubernetes auth method is enabled. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationMountPath))
+
+	flags.StringVar(&o.config.SecretsPrefix, "vault-secret-prefix", vaultsync.DefaultSecretsPrefix,
+		fmt.Sprintf("Prefix secrets in kubernetes. A vault secret with name 'confidential' will be synchronized in kubernetes with name '<prefix>-confidential'. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationSecretsPrefix))
+	flags.StringVar(&o.config.Requests, "requests", "",
+		fmt.Sprintf("The resource requests of the job's containers, e.g. 'cpu=100m,memory=64Mi'. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationRequests))
+	flags.StringVar(&o.config.Limits, "limits", "",
+		fmt.Sprintf("The resource limits of the job's containers, e.g. 'cpu=200m,memory=128Mi'. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationLimits))
+	flags.StringVar(&o.config.NodeSelector, "node-selector", "",
+		fmt.Sprintf("The node labels the job's pod is scheduled on, e.g. 'pool=infra'. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationNodeSelector))
+	flags.StringVar(&o.config.Tolerations, "tolerations", "",
+		fmt.Sprintf("The taints the job's pod tolerates as comma separated 'key[=value][:effect]', e.g. 'dedicated=infra:NoSchedule'. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationTolerations))
+	flags.StringVar(&o.config.Affinity, "affinity", "",
+		fmt.Sprintf("The affinity of the job's pod as JSON or YAML. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationAffinity))
+	flags.StringVar(&o.config.PriorityClassName, "priority-class-name", "",
+		fmt.Sprintf("The priority class of the job's pod. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationPriorityClassName))
+	flags.StringVar(&o.config.ActiveDeadline, "active-deadline", "",
+		fmt.Sprintf("The time the job may be active before it is terminated, e.g. '10m'. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationActiveDeadline))
+	flags.StringVar(&o.config.Hardened, "hardened", "true",
+		fmt.Sprintf("Run the job's containers with a security context that complies with the restricted pod security standard. Use --hardened=false to opt out. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationHardened))
+	flags.Lookup("hardened").NoOptDefVal = "true"
+	flags.StringVar(&o.config.KeepSuccessful, "keep-successful", strconv.Itoa(vaultsync.DefaultKeepSuccessful),
+		fmt.Sprintf("The number of successful finished sync jobs to keep when a new sync job is created. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationKeepSuccessful))
+	flags.StringVar(&o.config.KeepFailed, "keep-failed", strconv.Itoa(vaultsync.DefaultKeepFailed),
+		fmt.Sprintf("The number of failed finished sync jobs to keep when a new sync job is created. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationKeepFailed))
+	flags.StringVar(&o.config.TTL, "ttl", vaultsync.DefaultTTL,
+		fmt.Sprintf("The time after which a finished sync job is deleted by kubernetes, 0 keeps finished jobs. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationTTL))
+	flags.StringVar(&o.config.BackoffLimit, "backoff-limit", strconv.Itoa(vaultsync.DefaultBackoffLimit),
+		fmt.Sprintf("The number of retries before the sync job is considered failed. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.AnnotationBackoffLimit))
+	flags.StringVar(&o.config.PatchConfigMap, "patch-configmap", "",
+		fmt.Sprintf("The config map in the job's namespace with a strategic merge patch or JSON patch for the job in key '%s'. If not set, value is taken from namespace annotation '%s' if it exists.", vaultsync.PatchConfigMapKey, vaultsync.AnnotationPatchConfigMap))
+	flags.StringVar(&o.userSpecifiedPatchFile, "patch-file", "",
+		"A file with a strategic merge patch or JSON patch (RFC 6902) that is applied to the job, after the patch of the config map.")
+	o.addSourceFlags(flags)
+}
+
+// addSourceFlags adds the flags selecting the profile and the cluster
+// defaults, for commands that resolve only some of the settings.
+func (o *jobOptions) addSourceFlags(flags *pflag.FlagSet) {
+	o.flags = flags
+
+	flags.StringVar(&o.userSpecifiedProfile, "profile", "",
+		"Name of the profile in the configuration file providing the settings that are not set by a flag or a namespace annotation. "+
+			"If not set, the profile named like the current context or cluster is used if it exists.")
+	flags.StringVar(&o.userSpecifiedClusterDefaults, "defaults-configmap", dfltClusterDefaults,
+		"The config map (<namespace>/<name>) with cluster wide settings for namespaces without the corresponding annotation. "+
+			"Its keys are the annotation names without prefix, e.g. addr. Set it to an empty string to ignore cluster wide settings.")
+}
+
+// loadProfile loads the profile selected with --profile or by the current
+// kubeconfig context or cluster from the configuration file.
+func (o *jobOptions) loadProfile(configFlags *genericclioptions.ConfigFlags, rawConfig *api.Config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	contextName, cluster := currentContext(configFlags, rawConfig)
+
+	o.profile, err = cfg.profile(o.userSpecifiedProfile, contextName, cluster)
+	if err != nil {
+		return fmt.Errorf("%s in configuration file %s", err, path)
+	}
+
+	return nil
+}
+
+// newJob resolves the settings for namespace ns and builds the sync job
+// with b and the patches. It returns the job and the settings it was built
+// with. The receiver is not modified, so that newJob can be called for
+// several namespaces.
+func (o *jobOptions) newJob(ctx context.Context, clientset kubernetes.Interface, ns *v1.Namespace, b *vaultsync.Builder) (*batchv1.Job, *vaultsync.Config, error) {
+	opts, err := o.resolve(ns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.Patches, err = opts.patches(ctx, clientset, ns.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := opts.jobConfig()
+
+	batchJob, err := b.Build(&c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return batchJob, &c, nil
+}
+
+// jobConfig returns the settings of the resolved options o for the
+// builder. The default authenticator image is left to the builder, since
+// it depends on the auth method.
+func (o *jobOptions) jobConfig() vaultsync.Config {
+	c := o.config
+	if o.sources["auth-image"] == sourceDefault {
+		c.AuthImage = ""
+	}
+
+	return c
+}
+
+// resolve returns a copy of o with the settings for namespace ns.
+func (o *jobOptions) resolve(ns *v1.Namespace) (*jobOptions, error) {
+	opts := *o
+
+	err := opts.optionsFromNamespace(ns)
+
+	return &opts, err
+}
+
+// optionsFromNamespace sets the settings not specified by the user from the
+// namespace annotations or, if an annotation does not exist, from the
+// cluster defaults or the profile. Otherwise the default value of the flag
+// is kept. All settings are resolved, even if a required one is missing.
+func (o *jobOptions) optionsFromNamespace(ns *v1.Namespace) error {
+	flags := map[string]string{}
+
+	for _, s := range o.settings() {
+		if o.flags != nil && o.flags.Changed(settingFlags[s.Name]) {
+			flags[s.Name] = *s.Value
+		}
+	}
+
+	r := vaultsync.Resolver{
+		Config:    o.config,
+		Overrides: []vaultsync.Source{{Name: sourceFlag, Values: flags}},
+		Fallbacks: []vaultsync.Source{
+			{Name: sourceClusterDefaults, Values: o.clusterDefaults},
+			{Name: sourceConfig, Values: o.profile.Values()},
+		},
+	}
+
+	c, sources, err := r.Resolve(ns)
+	o.config, o.sources = *c, sources
+
+	return err
+}
@@ -0,0 +1,364 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var configuredAnnotations = map[string]string{
+	vaultsync.AnnotationSecretsPath:   "secret/annotation",
+	vaultsync.AnnotationSecretsPrefix: "annotation-",
+	vaultsync.AnnotationRole:          "annotation-role",
+	vaultsync.AnnotationAddr:          "https://annotation.vault.io",
+	vaultsync.AnnotationMountPath:     "annotation-mountpath",
+	vaultsync.AnnotationTrustSecret:   "annotation-trust",
+	vaultsync.AnnotationSyncImage:     "annotation-sync-image",
+	vaultsync.AnnotationAuthImage:     "annotation-auth-image",
+}
+
+// settingValues returns the values of the settings of o covered by
+// configuredAnnotations by name.
+func settingValues(o *jobOptions) map[string]string {
+	values := map[string]string{}
+
+	for _, s := range o.settings() {
+		if _, ok := configuredAnnotations[s.Annotation]; ok {
+			values[s.Name] = *s.Value
+		}
+	}
+
+	return values
+}
+
+func TestOptionsFromNamespace(t *testing.T) {
+	var tt = []struct {
+		name        string
+		flags       []string
+		annotations map[string]string
+		expected    jobOptions
+		expectedErr error
+	}{
+		{
+			"annotations only",
+			nil,
+			configuredAnnotations,
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/annotation",
+				SecretsPrefix: "annotation-",
+				Role:          "annotation-role",
+				Addr:          "https://annotation.vault.io",
+				MountPath:     "annotation-mountpath",
+				TrustSecret:   "annotation-trust",
+				SyncImage:     "annotation-sync-image",
+				AuthImage:     "annotation-auth-image",
+			}},
+			nil,
+		},
+		{
+			"flags take precedence over annotations",
+			[]string{
+				"--vault-secretspath=secret/flag",
+				"--vault-secret-prefix=flag-",
+				"--vault-role=flag-role",
+				"--vault-addr=https://flag.vault.io",
+				"--vault-mountpath=flag-mountpath",
+				"--vault-trust-secret=flag-trust",
+				"--vault-sync-image=flag-sync-image",
+				"--vault-auth-image=flag-auth-image",
+			},
+			configuredAnnotations,
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/flag",
+				SecretsPrefix: "flag-",
+				Role:          "flag-role",
+				Addr:          "https://flag.vault.io",
+				MountPath:     "flag-mountpath",
+				TrustSecret:   "flag-trust",
+				SyncImage:     "flag-sync-image",
+				AuthImage:     "flag-auth-image",
+			}},
+			nil,
+		},
+		{
+			"defaults without optional annotations",
+			nil,
+			map[string]string{
+				vaultsync.AnnotationSecretsPath: "secret/annotation",
+				vaultsync.AnnotationRole:        "annotation-role",
+				vaultsync.AnnotationAddr:        "https://annotation.vault.io",
+			},
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/annotation",
+				SecretsPrefix: vaultsync.DefaultSecretsPrefix,
+				Role:          "annotation-role",
+				Addr:          "https://annotation.vault.io",
+				MountPath:     vaultsync.DefaultMountPath,
+				SyncImage:     vaultsync.DefaultSyncImage,
+				AuthImage:     vaultsync.DefaultAuthImage,
+			}},
+			nil,
+		},
+		{
+			"required annotations replaced by flags",
+			[]string{
+				"--vault-secretspath=secret/flag",
+				"--vault-role=flag-role",
+				"--vault-addr=https://flag.vault.io",
+			},
+			nil,
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/flag",
+				SecretsPrefix: vaultsync.DefaultSecretsPrefix,
+				Role:          "flag-role",
+				Addr:          "https://flag.vault.io",
+				MountPath:     vaultsync.DefaultMountPath,
+				SyncImage:     vaultsync.DefaultSyncImage,
+				AuthImage:     vaultsync.DefaultAuthImage,
+			}},
+			nil,
+		},
+		{
+			"missing secrets path",
+			nil,
+			map[string]string{
+				vaultsync.AnnotationRole: "annotation-role",
+				vaultsync.AnnotationAddr: "https://annotation.vault.io",
+			},
+			jobOptions{},
+			vaultsync.ErrNotConfigured,
+		},
+		{
+			"missing role",
+			nil,
+			map[string]string{
+				vaultsync.AnnotationSecretsPath: "secret/annotation",
+				vaultsync.AnnotationAddr:        "https://annotation.vault.io",
+			},
+			jobOptions{},
+			vaultsync.ErrNotConfigured,
+		},
+		{
+			"approle without role",
+			[]string{"--vault-auth-method=approle"},
+			map[string]string{
+				vaultsync.AnnotationSecretsPath:   "secret/annotation",
+				vaultsync.AnnotationAddr:          "https://annotation.vault.io",
+				vaultsync.AnnotationAppRoleSecret: "approle",
+			},
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/annotation",
+				SecretsPrefix: vaultsync.DefaultSecretsPrefix,
+				Addr:          "https://annotation.vault.io",
+				MountPath:     vaultsync.DefaultMountPath,
+				SyncImage:     vaultsync.DefaultSyncImage,
+				AuthImage:     vaultsync.DefaultAuthImage,
+			}},
+			nil,
+		},
+		{
+			"approle without secret",
+			nil,
+			map[string]string{
+				vaultsync.AnnotationSecretsPath: "secret/annotation",
+				vaultsync.AnnotationRole:        "annotation-role",
+				vaultsync.AnnotationAddr:        "https://annotation.vault.io",
+				vaultsync.AnnotationAuthMethod:  vaultsync.AuthAppRole,
+			},
+			jobOptions{},
+			vaultsync.ErrNotConfigured,
+		},
+		{
+			"missing addr",
+			[]string{"--vault-role=flag-role"},
+			map[string]string{
+				vaultsync.AnnotationSecretsPath: "secret/annotation",
+			},
+			jobOptions{},
+			vaultsync.ErrNotConfigured,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			o := jobOptions{}
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			o.addFlags(flags)
+			require.NoError(t, flags.Parse(tc.flags))
+
+			ns := &v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Annotations: tc.annotations,
+				},
+			}
+
+			err := o.optionsFromNamespace(ns)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, settingValues(&tc.expected), settingValues(&o))
+		})
+	}
+}
+
+func TestOptionsFromNamespaceProfile(t *testing.T) {
+	p := profile{
+		SecretsPath:   "secret/profile",
+		SecretsPrefix: "profile-",
+		Role:          "profile-role",
+		Addr:          "https://profile.vault.io",
+		MountPath:     "profile-mountpath",
+		SyncImage:     "profile-sync-image",
+		AuthImage:     "profile-auth-image",
+		TrustSecret:   "profile-trust",
+	}
+
+	var tt = []struct {
+		name        string
+		flags       []string
+		annotations map[string]string
+		expected    jobOptions
+	}{
+		{
+			"profile only",
+			nil,
+			nil,
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/profile",
+				SecretsPrefix: "profile-",
+				Role:          "profile-role",
+				Addr:          "https://profile.vault.io",
+				MountPath:     "profile-mountpath",
+				TrustSecret:   "profile-trust",
+				SyncImage:     "profile-sync-image",
+				AuthImage:     "profile-auth-image",
+			}},
+		},
+		{
+			"annotations take precedence over profile",
+			nil,
+			configuredAnnotations,
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/annotation",
+				SecretsPrefix: "annotation-",
+				Role:          "annotation-role",
+				Addr:          "https://annotation.vault.io",
+				MountPath:     "annotation-mountpath",
+				TrustSecret:   "annotation-trust",
+				SyncImage:     "annotation-sync-image",
+				AuthImage:     "annotation-auth-image",
+			}},
+		},
+		{
+			"flags take precedence over profile",
+			[]string{
+				"--vault-role=flag-role",
+				"--vault-sync-image=flag-sync-image",
+			},
+			map[string]string{
+				vaultsync.AnnotationAddr: "https://annotation.vault.io",
+			},
+			jobOptions{config: vaultsync.Config{
+				SecretsPath:   "secret/profile",
+				SecretsPrefix: "profile-",
+				Role:          "flag-role",
+				Addr:          "https://annotation.vault.io",
+				MountPath:     "profile-mountpath",
+				TrustSecret:   "profile-trust",
+				SyncImage:     "flag-sync-image",
+				AuthImage:     "profile-auth-image",
+			}},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			o := jobOptions{}
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			o.addFlags(flags)
+			require.NoError(t, flags.Parse(tc.flags))
+
+			o.profile = p
+
+			ns := &v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Annotations: tc.annotations,
+				},
+			}
+
+			require.NoError(t, o.optionsFromNamespace(ns))
+			assert.Equal(t, settingValues(&tc.expected), settingValues(&o))
+		})
+	}
+}
+
+func TestOptionsFromNamespaceSources(t *testing.T) {
+	o := jobOptions{}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	o.addFlags(flags)
+
+	// explicitly specified values equal to the defaults take precedence as well
+	require.NoError(t, flags.Parse([]string{
+		"--vault-secret-prefix=" + vaultsync.DefaultSecretsPrefix,
+		"--vault-mountpath=" + vaultsync.DefaultMountPath,
+	}))
+
+	o.profile = profile{
+		Addr:      "https://profile.vault.io",
+		SyncImage: "profile-sync-image",
+		AuthImage: "profile-auth-image",
+	}
+	o.clusterDefaults = map[string]string{
+		"role":       "cluster-role",
+		"sync-image": "cluster-sync-image",
+	}
+
+	ns := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Annotations: map[string]string{
+				vaultsync.AnnotationSecretsPath:   "secret/annotation",
+				vaultsync.AnnotationRole:          "annotation-role",
+				vaultsync.AnnotationSecretsPrefix: "annotation-",
+				vaultsync.AnnotationMountPath:     "annotation-mountpath",
+			},
+		},
+	}
+
+	opts, err := o.resolve(ns)
+	require.NoError(t, err)
+
+	assert.Equal(t, vaultsync.DefaultSecretsPrefix, opts.config.SecretsPrefix)
+	assert.Equal(t, vaultsync.DefaultMountPath, opts.config.MountPath)
+	expectedSources := map[string]string{
+		"secrets-path":   sourceAnnotation,
+		"role":           sourceAnnotation,
+		"addr":           sourceConfig,
+		"mount-path":     sourceFlag,
+		"secrets-prefix": sourceFlag,
+		"sync-image":     sourceClusterDefaults,
+		"auth-image":     sourceConfig,
+		"trust-secret":   sourceDefault,
+		"requests":       sourceDefault,
+	}
+
+	for name, source := range expectedSources {
+		assert.Equal(t, source, opts.sources[name], name)
+	}
+
+	assert.Nil(t, o.sources, "resolve must not modify the receiver")
+}
@@ -0,0 +1,28 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var errNoSyncJob = errors.New("no vault-sync job found")
+
+// latestSyncJob returns the most recently created sync job in namespace.
+func latestSyncJob(ctx context.Context, clientset kubernetes.Interface, namespace string) (*batchv1.Job, error) {
+	jobs, err := vaultsync.ListJobs(ctx, clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%w in namespace %s", errNoSyncJob, namespace)
+	}
+
+	return &jobs[0], nil
+}
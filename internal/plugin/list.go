@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ListOptions provides information required to list sync jobs.
+type ListOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	rawConfig api.Config
+
+	genericclioptions.IOStreams
+}
+
+// NewListOptions provides an instance of ListOptions with default values
+func NewListOptions(streams genericclioptions.IOStreams) *ListOptions {
+	o := &ListOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdList provides a cobra command wrapping ListOptions
+func NewCmdList(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdList(NewListOptions(streams))
+}
+
+// newCmdList provides a cobra command wrapping o.
+func newCmdList(o *ListOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "list",
+		Short:        "List the sync jobs created by the plugin",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for listing the jobs
+func (o *ListOptions) Complete(cmd *cobra.Command, args []string) error {
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	return err
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *ListOptions) Validate() error {
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run prints a table of all sync jobs in the namespace, newest first.
+func (o *ListOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	jobs, err := vaultsync.ListJobs(ctx, clientset, o.currentNamespace)
+	if err != nil {
+		return err
+	}
+
+	if len(jobs) == 0 {
+		fmt.Fprintf(o.ErrOut, "No sync jobs found in %s namespace.\n", o.currentNamespace)
+		return nil
+	}
+
+	w := printers.GetNewTabWriter(o.Out)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "NAME\tSTATUS\tSECRETS\tAGE")
+
+	for i := range jobs {
+		j := &jobs[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			j.Name,
+			vaultsync.JobStatus(j),
+			jobSecrets(j),
+			duration.HumanDuration(metav1.Now().Sub(j.CreationTimestamp.Time)),
+		)
+	}
+
+	return nil
+}
+
+// jobSecrets returns the vault secrets a sync job synchronizes.
+func jobSecrets(j *batchv1.Job) string {
+	for _, c := range j.Spec.Template.Spec.Containers {
+		for _, e := range c.Env {
+			if e.Name == "VAULT_SECRETS" {
+				return e.Value
+			}
+		}
+	}
+
+	return "<unknown>"
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// newTestCmdList returns the list command using clientset.
+func newTestCmdList(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewListOptions(streams)
+	o.clientset = clientset
+
+	return newCmdList(o)
+}
+
+func TestList(t *testing.T) {
+	withSecrets := syncJob("vault-sync-2", time.Hour, vaultsync.StatusFailed)
+	withSecrets.Spec.Template.Spec.Containers = []v1.Container{{
+		Name: "sync",
+		Env:  []v1.EnvVar{{Name: "VAULT_SECRETS", Value: "secret/team/confidential"}},
+	}}
+
+	clientset := newFakeClientset(
+		syncJob("vault-sync-1", 2*time.Hour, vaultsync.StatusSucceeded),
+		withSecrets,
+		syncJob("vault-sync-3", time.Minute, vaultsync.StatusRunning),
+	)
+
+	out, err := runCommand(t, newTestCmdList, clientset)
+	require.NoError(t, err)
+
+	rows := [][]string{}
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		rows = append(rows, strings.Fields(l))
+	}
+
+	assert.Equal(t, [][]string{
+		{"NAME", "STATUS", "SECRETS", "AGE"},
+		{"vault-sync-3", vaultsync.StatusRunning, "<unknown>", "60s"},
+		{"vault-sync-2", vaultsync.StatusFailed, "secret/team/confidential", "60m"},
+		{"vault-sync-1", vaultsync.StatusSucceeded, "<unknown>", "120m"},
+	}, rows)
+
+	out, err = runCommand(t, newTestCmdList, newFakeClientset())
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
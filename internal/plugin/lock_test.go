@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSyncRunningJob(t *testing.T) {
+	var tt = []struct {
+		name         string
+		args         []string
+		finish       bool
+		expectedErr  error
+		expectedJobs int
+	}{
+		{"refuse", nil, false, vaultsync.ErrRunning, 1},
+		{"replace", []string{"--replace"}, false, nil, 1},
+		{"wait for running", []string{"--wait-for-running", "--timeout=5s"}, true, nil, 1},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			running := syncJob("vault-sync-running", time.Minute, vaultsync.StatusRunning)
+			running.Annotations = map[string]string{vaultsync.AnnotationCreatedBy: "alice@host"}
+
+			clientset := newFakeClientset(running)
+			if tc.finish {
+				clientset.PrependWatchReactor("jobs", finishOnWatch(clientset.Tracker(), running))
+			}
+
+			_, err := runCommand(t, newTestCmdSync, clientset, tc.args...)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				assert.Contains(t, err.Error(), "alice@host")
+			} else {
+				require.NoError(t, err)
+			}
+
+			jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			assert.Len(t, jobs.Items, tc.expectedJobs)
+
+			_, err = clientset.CoordinationV1().Leases(testNamespace).Get(context.Background(), "vault-sync", metav1.GetOptions{})
+			assert.True(t, err != nil, "the lease must be released")
+		})
+	}
+}
+
+func TestSyncLocked(t *testing.T) {
+	holder := "bob@host"
+	seconds := int32(vaultsync.LeaseDuration.Seconds())
+	now := metav1.NowMicro()
+
+	clientset := newFakeClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault-sync",
+			Namespace: testNamespace,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	})
+
+	_, err := runCommand(t, newTestCmdSync, clientset)
+	require.ErrorIs(t, err, vaultsync.ErrLocked)
+	assert.Contains(t, err.Error(), "held by bob@host since")
+
+	// a stale lease is taken over
+	stale := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+
+	lease, err := clientset.CoordinationV1().Leases(testNamespace).Get(context.Background(), "vault-sync", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	lease.Spec.RenewTime = &stale
+	_, err = clientset.CoordinationV1().Leases(testNamespace).Update(context.Background(), lease, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	_, err = runCommand(t, newTestCmdSync, clientset)
+	require.NoError(t, err)
+}
+
+// finishOnWatch returns a watch reactor that completes the running job in
+// tracker when it is watched. The finished job is then deleted by the default
+// retention.
+func finishOnWatch(tracker k8stesting.ObjectTracker, running *batchv1.Job) k8stesting.WatchReactionFunc {
+	return func(action k8stesting.Action) (bool, watch.Interface, error) {
+		finished := running.DeepCopy()
+		finished.Status.Active = 0
+		finished.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+
+		if err := tracker.Update(action.GetResource(), finished, running.Namespace); err != nil {
+			return true, nil, err
+		}
+
+		w := watch.NewFakeWithChanSize(1, false)
+		w.Modify(finished)
+
+		return true, w, nil
+	}
+}
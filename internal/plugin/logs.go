@@ -0,0 +1,306 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const logPollInterval = time.Second
+
+var logsExample = `
+	# print the logs of the last sync job
+	%[1]s %[2]s logs
+
+	# print the logs of a specific sync job
+	%[1]s %[2]s logs vault-sync-20190412-101357
+
+	# stream the logs of the last sync job until it has finished
+	%[1]s %[2]s logs --follow
+`
+
+// LogsOptions provides information required to print the logs of a sync job.
+type LogsOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	userSpecifiedFollow bool
+
+	rawConfig api.Config
+	args      []string
+
+	genericclioptions.IOStreams
+}
+
+// NewLogsOptions provides an instance of LogsOptions with default values
+func NewLogsOptions(streams genericclioptions.IOStreams) *LogsOptions {
+	o := &LogsOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdLogs provides a cobra command wrapping LogsOptions
+func NewCmdLogs(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdLogs(NewLogsOptions(streams))
+}
+
+// newCmdLogs provides a cobra command wrapping o.
+func newCmdLogs(o *LogsOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "logs [job]",
+		Short:        "Print the logs of a sync job",
+		Example:      fmt.Sprintf(logsExample, "kubectl", Name),
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&o.userSpecifiedFollow, "follow", "f", false,
+		"Stream the logs until the job has finished.")
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for printing the logs
+func (o *LogsOptions) Complete(cmd *cobra.Command, args []string) error {
+	o.args = args
+
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	return err
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *LogsOptions) Validate() error {
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run prints the logs of the authenticator and synchronizer container.
+func (o *LogsOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	jobName := ""
+	if len(o.args) > 0 {
+		jobName = o.args[0]
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+		defer cancel()
+
+		j, err := latestSyncJob(ctx, clientset, o.currentNamespace)
+		if err != nil {
+			return err
+		}
+
+		jobName = j.Name
+	}
+
+	if o.userSpecifiedFollow {
+		return followJobLogs(context.Background(), clientset, o.currentNamespace, jobName, o.Out)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	return printJobLogs(ctx, clientset, o.currentNamespace, jobName, o.Out)
+}
+
+// jobPod returns the most recently created pod of a job.
+func jobPod(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) (*v1.Pod, error) {
+	pods, err := vaultsync.JobPods(ctx, clientset, namespace, jobName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no pod found for job %s", jobName)
+	}
+
+	return &pods[0], nil
+}
+
+// printJobLogs writes the logs of all init containers and containers of
+// the job's pod to out.
+func printJobLogs(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string, out io.Writer) error {
+	pod, err := jobPod(ctx, clientset, namespace, jobName)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range podContainers(pod) {
+		if err := streamContainerLogs(ctx, clientset, pod, c, false, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// followJobLogs streams the logs of the job's pods to out until the job
+// has finished. Pods created for retries are followed one after the other.
+func followJobLogs(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string, out io.Writer) error {
+	seen := map[string]bool{}
+
+	for {
+		var pod *v1.Pod
+
+		finished := false
+
+		err := wait.PollUntilContextCancel(ctx, logPollInterval, true, func(ctx context.Context) (bool, error) {
+			pods, err := vaultsync.JobPods(ctx, clientset, namespace, jobName)
+			if err != nil {
+				return false, err
+			}
+
+			// follow the oldest pod that has not been followed yet
+			for i := len(pods) - 1; i >= 0; i-- {
+				if !seen[pods[i].Name] {
+					pod = &pods[i]
+					return true, nil
+				}
+			}
+
+			j, err := clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+			if err != nil {
+				return false, fmt.Errorf("could not get batch job %s: %s", jobName, err)
+			}
+
+			finished = vaultsync.JobStatus(j) != vaultsync.StatusRunning
+
+			return finished, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if finished {
+			return nil
+		}
+
+		seen[pod.Name] = true
+
+		if err := followPodLogs(ctx, clientset, pod, out); err != nil {
+			return err
+		}
+	}
+}
+
+// followPodLogs streams the logs of all containers of pod to out, one after
+// the other. Containers that never start are skipped.
+func followPodLogs(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, out io.Writer) error {
+	for _, c := range podContainers(pod) {
+		started := false
+
+		err := wait.PollUntilContextCancel(ctx, logPollInterval, true, func(ctx context.Context) (bool, error) {
+			p, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, fmt.Errorf("could not get pod %s: %s", pod.Name, err)
+			}
+
+			if err := vaultsync.PodError(p); err != nil && err.Waiting {
+				return false, err
+			}
+
+			state := containerState(p, c)
+			started = state.Running != nil || state.Terminated != nil
+
+			return started || p.Status.Phase == v1.PodSucceeded || p.Status.Phase == v1.PodFailed, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if !started {
+			continue
+		}
+
+		if err := streamContainerLogs(ctx, clientset, pod, c, true, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// podContainers returns the names of the pod's init containers and
+// containers in the order they are started.
+func podContainers(pod *v1.Pod) []string {
+	containers := []string{}
+	for i := range pod.Spec.InitContainers {
+		containers = append(containers, pod.Spec.InitContainers[i].Name)
+	}
+
+	for i := range pod.Spec.Containers {
+		containers = append(containers, pod.Spec.Containers[i].Name)
+	}
+
+	return containers
+}
+
+// containerState returns the state of the (init) container with the given name.
+func containerState(pod *v1.Pod, name string) v1.ContainerState {
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for i := range statuses {
+			if statuses[i].Name == name {
+				return statuses[i].State
+			}
+		}
+	}
+
+	return v1.ContainerState{}
+}
+
+func streamContainerLogs(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, container string, follow bool, out io.Writer) error {
+	req := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+		Container: container,
+		Follow:    follow,
+	})
+
+	stream, err := req.Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get logs of container %s in pod %s: %s", container, pod.Name, err)
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(out, stream); err != nil {
+		return fmt.Errorf("could not read logs of container %s in pod %s: %s", container, pod.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// newTestCmdLogs returns the logs command using clientset.
+func newTestCmdLogs(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewLogsOptions(streams)
+	o.clientset = clientset
+
+	return newCmdLogs(o)
+}
+
+// podLogs returns the pod and container name as logs.
+func podLogs(pod, container string) string {
+	return pod + "/" + container + "\n"
+}
+
+// jobPodWithStates returns a pod of job jobName created age ago with an init
+// container auth and a container sync in the given states.
+func jobPodWithStates(name, jobName string, age time.Duration, phase v1.PodPhase, auth, sync v1.ContainerState) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         testNamespace,
+			Labels:            map[string]string{"job-name": jobName},
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "auth"}},
+			Containers:     []v1.Container{{Name: "sync"}},
+		},
+		Status: v1.PodStatus{
+			Phase:                 phase,
+			InitContainerStatuses: []v1.ContainerStatus{{Name: "auth", State: auth}},
+			ContainerStatuses:     []v1.ContainerStatus{{Name: "sync", State: sync}},
+		},
+	}
+}
+
+func terminated(exitCode int32) v1.ContainerState {
+	return v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: exitCode}}
+}
+
+func TestLogs(t *testing.T) {
+	clientset := logsClientset{newFakeClientset(
+		syncJob("vault-sync-old", 2*time.Hour, vaultsync.StatusFailed),
+		syncJob("vault-sync-new", time.Hour, vaultsync.StatusSucceeded),
+		jobPodWithStates("vault-sync-old-1", "vault-sync-old", 2*time.Hour, v1.PodFailed, terminated(0), terminated(1)),
+		jobPodWithStates("vault-sync-new-1", "vault-sync-new", time.Hour, v1.PodSucceeded, terminated(0), terminated(0)),
+	), podLogs}
+
+	var tt = []struct {
+		name        string
+		args        []string
+		expectedOut string
+	}{
+		{"latest job", nil, "vault-sync-new-1/auth\nvault-sync-new-1/sync\n"},
+		{"given job", []string{"vault-sync-old"}, "vault-sync-old-1/auth\nvault-sync-old-1/sync\n"},
+		{"follow latest job", []string{"--follow"}, "vault-sync-new-1/auth\nvault-sync-new-1/sync\n"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runCommand(t, newTestCmdLogs, clientset, tc.args...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedOut, out)
+		})
+	}
+}
+
+// scheduledJob returns j as created by the cron job of the schedule command.
+func scheduledJob(j *batchv1.Job) *batchv1.Job {
+	controller := true
+	j.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "batch/v1",
+		Kind:       "CronJob",
+		Name:       job.Name,
+		Controller: &controller,
+	}}
+
+	return j
+}
+
+func TestLogsScheduledJob(t *testing.T) {
+	clientset := logsClientset{newFakeClientset(
+		syncJob("vault-sync-old", 2*time.Hour, vaultsync.StatusSucceeded),
+		scheduledJob(syncJob("vault-sync-scheduled", time.Hour, vaultsync.StatusSucceeded)),
+		jobPodWithStates("vault-sync-old-1", "vault-sync-old", 2*time.Hour, v1.PodSucceeded, terminated(0), terminated(0)),
+		jobPodWithStates("vault-sync-scheduled-1", "vault-sync-scheduled", time.Hour, v1.PodSucceeded, terminated(0), terminated(0)),
+	), podLogs}
+
+	out, err := runCommand(t, newTestCmdLogs, clientset)
+	require.NoError(t, err)
+	assert.Equal(t, "vault-sync-scheduled-1/auth\nvault-sync-scheduled-1/sync\n", out)
+}
+
+func TestLogsNoJob(t *testing.T) {
+	_, err := runCommand(t, newTestCmdLogs, newFakeClientset())
+	require.ErrorIs(t, err, errNoSyncJob)
+
+	_, err = runCommand(t, newTestCmdLogs, newFakeClientset(syncJob("vault-sync-1", time.Hour, vaultsync.StatusSucceeded)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no pod found for job vault-sync-1")
+}
+
+func TestLogsFollowRetries(t *testing.T) {
+	clientset := logsClientset{newFakeClientset(
+		syncJob("vault-sync-1", 3*time.Minute, vaultsync.StatusSucceeded),
+		// the authenticator of the first pod failed, so its synchronizer
+		// never started
+		jobPodWithStates("vault-sync-1-a", "vault-sync-1", 3*time.Minute, v1.PodFailed, terminated(1), v1.ContainerState{}),
+		jobPodWithStates("vault-sync-1-b", "vault-sync-1", 2*time.Minute, v1.PodFailed, terminated(0), terminated(1)),
+		jobPodWithStates("vault-sync-1-c", "vault-sync-1", time.Minute, v1.PodSucceeded, terminated(0), terminated(0)),
+	), podLogs}
+
+	out, err := runCommand(t, newTestCmdLogs, clientset, "--follow")
+	require.NoError(t, err)
+	assert.Equal(t, "vault-sync-1-a/auth\n"+
+		"vault-sync-1-b/auth\nvault-sync-1-b/sync\n"+
+		"vault-sync-1-c/auth\nvault-sync-1-c/sync\n", out, "the pods are followed oldest first")
+}
@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const dfltConcurrency = 5
+
+// validateNamespaces validates the flags used to synchronize several namespaces.
+func (o *SyncOptions) validateNamespaces() error {
+	if o.userSpecifiedDryRun != dryRunNone {
+		return errors.New("--yaml and --dry-run can not be used with --all-namespaces or --namespace-selector")
+	}
+
+	if o.userSpecifiedFollow {
+		return errors.New("--follow can not be used with --all-namespaces or --namespace-selector")
+	}
+
+	if o.outputFormat == outputName {
+		return errors.New("--output=name can not be used with --all-namespaces or --namespace-selector")
+	}
+
+	if o.userSpecifiedConcurrency < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+
+	return nil
+}
+
+// runNamespaces synchronizes all selected namespaces with a bounded number of
+// workers and prints a result table. With --all-namespaces only namespaces
+// with a secrets path annotation are selected, a namespace selector selects
+// all matching namespaces.
+func (o *SyncOptions) runNamespaces(clientset kubernetes.Interface) error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.userSpecifiedTimeout)
+	defer cancel()
+
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: o.userSpecifiedNamespaceSelector})
+	if err != nil {
+		return fmt.Errorf("could not list namespaces: %s", err)
+	}
+
+	namespaces := []v1.Namespace{}
+
+	for i := range list.Items {
+		if _, ok := list.Items[i].GetAnnotations()[vaultsync.AnnotationSecretsPath]; ok || o.userSpecifiedNamespaceSelector != "" {
+			namespaces = append(namespaces, list.Items[i])
+		}
+	}
+
+	if len(namespaces) == 0 {
+		return errors.New("no namespaces found to synchronize")
+	}
+
+	// the workers print progress messages and warnings concurrently
+	mu := &sync.Mutex{}
+	o.Out = &lockedWriter{mu: mu, w: o.Out}
+	o.ErrOut = &lockedWriter{mu: mu, w: o.ErrOut}
+
+	results := make([]*SyncResult, len(namespaces))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < o.userSpecifiedConcurrency; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				results[i] = o.syncNamespace(clientset, &namespaces[i])
+			}
+		}()
+	}
+
+	for i := range namespaces {
+		queue <- i
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return o.printResults(results)
+}
+
+// lockedWriter serializes the writes to w, so that the messages written
+// concurrently do not interleave.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+// syncNamespace creates the sync job in namespace ns and waits for it if
+// requested.
+func (o *SyncOptions) syncNamespace(clientset kubernetes.Interface, ns *v1.Namespace) *SyncResult {
+	r := newSyncResult(ns.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.userSpecifiedTimeout)
+	defer cancel()
+
+	batchJob, secretPath, keep, err := o.newJob(ctx, clientset, ns)
+	if err != nil {
+		if errors.Is(err, vaultsync.ErrNotConfigured) {
+			r.fail(err)
+			r.Status = syncSkipped
+
+			return r
+		}
+
+		o.recordFailure(clientset, ns, r, err)
+
+		return r
+	}
+
+	r.SecretPath = secretPath
+
+	if w := podSecurityWarning(ns, batchJob); w != "" {
+		fmt.Fprintf(o.ErrOut, "Warning: %s\n", w)
+	}
+
+	start := time.Now()
+
+	created, err := o.createJob(ctx, clientset, ns.Name, batchJob, keep)
+	if err != nil {
+		o.recordFailure(clientset, ns, r, err)
+		return r
+	}
+
+	r.JobName = created.Name
+
+	r.Status = syncCreated
+
+	o.recordSync(clientset, ns, r)
+
+	if !o.userSpecifiedWait {
+		return r
+	}
+
+	err = o.waitForJob(ctx, clientset, ns.Name, created.Name)
+	r.Duration = since(start)
+
+	if err != nil {
+		r.fail(err)
+		o.recordSync(clientset, ns, r)
+
+		return r
+	}
+
+	r.Status = vaultsync.StatusSucceeded
+
+	if o.userSpecifiedPrune {
+		r.Pruned, err = o.prune(clientset, ns.Name, created.Name, true, io.Discard)
+		if err != nil {
+			r.fail(err)
+		} else if len(r.Pruned) > 0 {
+			r.Reason = fmt.Sprintf("pruned %d secrets", len(r.Pruned))
+		}
+	}
+
+	o.recordSync(clientset, ns, r)
+
+	return r
+}
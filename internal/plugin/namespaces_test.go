@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// teamNamespace returns a namespace with the label team and annotations.
+func teamNamespace(name, team string, annotations map[string]string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Labels:      map[string]string{"team": team},
+		Annotations: annotations,
+	}}
+}
+
+// resultRows returns the rows of a result table by namespace.
+func resultRows(out string) map[string][]string {
+	rows := map[string][]string{}
+
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) > 0 && fields[0] != "NAMESPACE" {
+			rows[fields[0]] = fields[1:]
+		}
+	}
+
+	return rows
+}
+
+func TestSyncNamespaces(t *testing.T) {
+	unconfigured := map[string]string{vaultsync.AnnotationSecretsPath: "secret/unconfigured"}
+
+	var tt = []struct {
+		name            string
+		args            []string
+		expectedErr     string
+		expectedResults map[string]string
+	}{
+		{
+			"all namespaces",
+			[]string{"--all-namespaces"},
+			"synchronization failed in 1 of 4 namespaces",
+			map[string]string{
+				testNamespace:  syncCreated,
+				"team-a":       syncCreated,
+				"running":      vaultsync.StatusFailed,
+				"unconfigured": syncSkipped,
+			},
+		},
+		{
+			"namespace selector",
+			[]string{"--namespace-selector", "team=a", "--concurrency=1"},
+			"",
+			map[string]string{
+				"team-a":        syncCreated,
+				"no-annotation": syncSkipped,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			running := syncJob("vault-sync-running", time.Minute, vaultsync.StatusRunning)
+			running.Namespace = "running"
+
+			clientset := newFakeClientset(
+				teamNamespace("team-a", "a", configuredAnnotations),
+				teamNamespace("no-annotation", "a", nil),
+				teamNamespace("unconfigured", "b", unconfigured),
+				teamNamespace("running", "b", configuredAnnotations),
+				running,
+			)
+
+			out, err := runCommand(t, newTestCmdSync, clientset, tc.args...)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			rows := resultRows(out)
+			require.Len(t, rows, len(tc.expectedResults))
+
+			for namespace, status := range tc.expectedResults {
+				require.Contains(t, rows, namespace)
+				assert.Contains(t, rows[namespace], status, namespace)
+
+				if status == syncSkipped {
+					assert.Contains(t, strings.Join(rows[namespace], " "), "is not configured for vault synchronization")
+				}
+
+				if status != syncCreated {
+					continue
+				}
+
+				jobs, err := clientset.BatchV1().Jobs(namespace).List(context.Background(), metav1.ListOptions{})
+				require.NoError(t, err)
+				assert.Len(t, jobs.Items, 1, namespace)
+			}
+		})
+	}
+}
+
+func TestSyncNamespacesValidate(t *testing.T) {
+	var tt = []struct {
+		name        string
+		args        []string
+		expectedErr string
+	}{
+		{"dry run", []string{"--all-namespaces", "--dry-run=client"}, "--yaml and --dry-run can not be used"},
+		{"follow", []string{"--all-namespaces", "--follow"}, "--follow can not be used"},
+		{"output name", []string{"--all-namespaces", "-o", "name"}, "--output=name can not be used"},
+		{"concurrency", []string{"--all-namespaces", "--concurrency=0"}, "--concurrency must be at least 1"},
+		{"no namespaces", []string{"--namespace-selector", "team=none"}, "no namespaces found"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := runCommand(t, newTestCmdSync, newFakeClientset(), tc.args...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// loadPatchFile reads the patch given with --patch-file.
+func (o *jobOptions) loadPatchFile() error {
+	if o.userSpecifiedPatchFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(o.userSpecifiedPatchFile)
+	if err != nil {
+		return fmt.Errorf("could not read patch file: %s", err)
+	}
+
+	o.filePatch = data
+
+	return nil
+}
+
+// patches returns the patch of the config map configured for namespace
+// and then the patch file.
+func (o *jobOptions) patches(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]vaultsync.Patch, error) {
+	patches := []vaultsync.Patch{}
+
+	p, err := vaultsync.ConfigMapPatch(ctx, clientset, namespace, &o.config)
+	if err != nil {
+		return nil, err
+	}
+
+	if p != nil {
+		patches = append(patches, *p)
+	}
+
+	if o.filePatch != nil {
+		patches = append(patches, vaultsync.Patch{Source: "file " + o.userSpecifiedPatchFile, Data: o.filePatch})
+	}
+
+	return patches, nil
+}
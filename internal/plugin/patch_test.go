@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestSyncPatch(t *testing.T) {
+	clientset := newFakeClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault-sync-patch",
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			vaultsync.PatchConfigMapKey: `{"metadata": {"labels": {"cost-center": "4711", "team": "configmap"}}}`,
+		},
+	})
+
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), testNamespace, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	ns.Annotations[vaultsync.AnnotationPatchConfigMap] = "vault-sync-patch"
+	_, err = clientset.CoreV1().Namespaces().Update(context.Background(), ns, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	patchFile := filepath.Join(t.TempDir(), "patch.yaml")
+	require.NoError(t, os.WriteFile(patchFile, []byte("metadata:\n  labels:\n    team: file\n"), 0o600))
+
+	out, err := runCommand(t, newTestCmdSync, clientset, "--yaml", "--patch-file", patchFile)
+	require.NoError(t, err)
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(out), nil, nil)
+	require.NoError(t, err)
+
+	j := obj.(*batchv1.Job)
+	assert.Equal(t, "4711", j.Labels["cost-center"])
+	assert.Equal(t, "file", j.Labels["team"], "the patch file is applied last")
+
+	require.NoError(t, os.WriteFile(patchFile, []byte(`[{"op": "remove", "path": "/spec/template/spec/initContainers"}]`), 0o600))
+
+	_, err = runCommand(t, newTestCmdSync, clientset, "--patch-file", patchFile)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "container vault-auth is missing")
+}
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/info"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+const (
+	testNamespace  = "test"
+	testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: test
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: test
+current-context: test
+`
+)
+
+// testCommand creates a command that uses streams and clientset.
+type testCommand func(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command
+
+// runCommand executes the command created by newCmd with args against
+// clientset and returns its standard output.
+func runCommand(t *testing.T, newCmd testCommand, clientset kubernetes.Interface, args ...string) (string, error) {
+	t.Helper()
+
+	return runCommandWithInput(t, newCmd, clientset, "", args...)
+}
+
+// runCommandWithInput executes the command created by newCmd with args and
+// standard input in against clientset and returns its standard output.
+func runCommandWithInput(t *testing.T, newCmd testCommand, clientset kubernetes.Interface, in string, args ...string) (string, error) {
+	t.Helper()
+
+	kubeconfig := setupTestConfig(t)
+
+	streams, stdin, out, _ := genericclioptions.NewTestIOStreams()
+	stdin.WriteString(in)
+
+	cmd := newCmd(streams, func() (kubernetes.Interface, error) {
+		return clientset, nil
+	})
+	cmd.SetOut(out)
+	cmd.SilenceErrors = true
+	cmd.SetArgs(append([]string{"--kubeconfig", kubeconfig}, args...))
+
+	err := cmd.Execute()
+
+	return out.String(), err
+}
+
+// setupTestConfig writes a kubeconfig with the current namespace test and
+// returns its path. The plugin's configuration file is looked up in an
+// empty directory.
+func setupTestConfig(t *testing.T) string {
+	t.Helper()
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600))
+
+	return kubeconfig
+}
+
+// newFakeClientset returns a fake clientset containing a configured test
+// namespace and objects.
+func newFakeClientset(objects ...runtime.Object) *fake.Clientset {
+	ns := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testNamespace,
+			Annotations: configuredAnnotations,
+		},
+	}
+
+	return fake.NewSimpleClientset(append(objects, ns)...)
+}
+
+// logsClientset is a fake clientset that returns the logs of the pods'
+// containers returned by logs, since the fake clientset always returns
+// "fake logs".
+type logsClientset struct {
+	*fake.Clientset
+	logs func(pod, container string) string
+}
+
+// staticLogs returns the same logs for all containers.
+func staticLogs(logs string) func(pod, container string) string {
+	return func(string, string) string {
+		return logs
+	}
+}
+
+func (c logsClientset) CoreV1() corev1.CoreV1Interface {
+	return logsCoreV1{c.Clientset.CoreV1(), c.logs}
+}
+
+type logsCoreV1 struct {
+	corev1.CoreV1Interface
+	logs func(pod, container string) string
+}
+
+func (c logsCoreV1) Pods(namespace string) corev1.PodInterface {
+	return logsPods{c.CoreV1Interface.Pods(namespace), c.logs}
+}
+
+type logsPods struct {
+	corev1.PodInterface
+	logs func(pod, container string) string
+}
+
+func (p logsPods) GetLogs(name string, opts *v1.PodLogOptions) *restclient.Request {
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(p.logs(name, opts.Container)))}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         v1.SchemeGroupVersion,
+		VersionedAPIPath:     "/api/v1/namespaces/" + testNamespace + "/pods/" + name + "/log",
+	}
+
+	return client.Request()
+}
+
+func TestRootArgs(t *testing.T) {
+	streams, _, _, _ := genericclioptions.NewTestIOStreams()
+	root := NewCmdVaultSync(streams, info.Version{})
+
+	var tt = []struct {
+		name          string
+		args          []string
+		expectedError string
+	}{
+		{"no secret", nil, ""},
+		{"secret", []string{"confidential"}, ""},
+		{"mistyped subcommand", []string{"stauts"}, "unknown command \"stauts\" for \"vault_sync\"\n\nDid you mean this?\n\tstatus\n\n" +
+			"Use \"vault_sync sync stauts\" to synchronize the vault secret stauts"},
+		{"two secrets", []string{"confidential", "secret"}, "accepts at most 1 arg(s), received 2"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			err := root.Args(root, tc.args)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
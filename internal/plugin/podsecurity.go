@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	podSecurityRestricted   = "restricted"
+)
+
+// podSecurityWarning returns a warning if namespace ns enforces the
+// restricted pod security standard and the pod of batchJob violates it. It
+// returns an empty string otherwise. The other levels are not checked,
+// since the sync job never uses host namespaces, host paths or privileged
+// containers.
+func podSecurityWarning(ns *v1.Namespace, batchJob *batchv1.Job) string {
+	if ns.Labels[podSecurityEnforceLabel] != podSecurityRestricted {
+		return ""
+	}
+
+	violations := restrictedViolations(&batchJob.Spec.Template.Spec)
+	if len(violations) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("namespace %s enforces the %s pod security standard, the pod of job %s would be rejected: %s",
+		ns.Name, podSecurityRestricted, batchJob.Name, strings.Join(violations, ", "))
+}
+
+// restrictedViolations returns the violations of the restricted pod
+// security standard by spec.
+func restrictedViolations(spec *v1.PodSpec) []string {
+	violations := []string{}
+
+	podContext := spec.SecurityContext
+	if podContext == nil {
+		podContext = &v1.PodSecurityContext{}
+	}
+
+	containers := append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...)
+
+	for i := range containers {
+		c := &containers[i]
+
+		sc := c.SecurityContext
+		if sc == nil {
+			sc = &v1.SecurityContext{}
+		}
+
+		if !isTrue(sc.RunAsNonRoot) && !(isTrue(podContext.RunAsNonRoot) && sc.RunAsNonRoot == nil) {
+			violations = append(violations, fmt.Sprintf("container %s must set runAsNonRoot=true", c.Name))
+		}
+
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			violations = append(violations, fmt.Sprintf("container %s must set allowPrivilegeEscalation=false", c.Name))
+		}
+
+		if !dropsAllCapabilities(sc.Capabilities) {
+			violations = append(violations, fmt.Sprintf("container %s must drop ALL capabilities", c.Name))
+		}
+
+		if !validSeccompProfile(sc.SeccompProfile) && !(sc.SeccompProfile == nil && validSeccompProfile(podContext.SeccompProfile)) {
+			violations = append(violations, fmt.Sprintf("container %s must set seccompProfile.type to RuntimeDefault or Localhost", c.Name))
+		}
+	}
+
+	for i := range spec.Volumes {
+		vs := spec.Volumes[i].VolumeSource
+		if vs.ConfigMap == nil && vs.CSI == nil && vs.DownwardAPI == nil && vs.EmptyDir == nil && vs.Ephemeral == nil &&
+			vs.PersistentVolumeClaim == nil && vs.Projected == nil && vs.Secret == nil {
+			violations = append(violations, fmt.Sprintf("volume %s has a restricted volume type", spec.Volumes[i].Name))
+		}
+	}
+
+	return violations
+}
+
+func dropsAllCapabilities(c *v1.Capabilities) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, add := range c.Add {
+		if add != "NET_BIND_SERVICE" {
+			return false
+		}
+	}
+
+	for _, drop := range c.Drop {
+		if drop == "ALL" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validSeccompProfile(p *v1.SeccompProfile) bool {
+	return p != nil && (p.Type == v1.SeccompProfileTypeRuntimeDefault || p.Type == v1.SeccompProfileTypeLocalhost)
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodSecurityWarning(t *testing.T) {
+	restricted := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "restricted",
+			Labels: map[string]string{podSecurityEnforceLabel: podSecurityRestricted},
+		},
+	}
+	baseline := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "baseline",
+			Labels: map[string]string{podSecurityEnforceLabel: "baseline"},
+		},
+	}
+
+	hardened := job.New(job.WithRestrictedSecurityContext(), job.WithTruststore("vault-tls"))
+	unhardened := job.New()
+
+	assert.Empty(t, podSecurityWarning(restricted, hardened))
+	assert.Empty(t, podSecurityWarning(baseline, unhardened))
+
+	w := podSecurityWarning(restricted, unhardened)
+	assert.Contains(t, w, "container vault-auth must set runAsNonRoot=true")
+	assert.Contains(t, w, "container vault-sync must drop ALL capabilities")
+}
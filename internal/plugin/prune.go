@@ -0,0 +1,402 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// pruneLabel set to "false" protects a secret from being pruned.
+	pruneLabel = "sync.vault.postfinance.ch/prune"
+
+	syncContainerName = "vault-sync"
+)
+
+var (
+	// syncedSecretLog matches the log line of the synchronizer for each
+	// secret it created or updated.
+	syncedSecretLog = regexp.MustCompile(`(?m)\b(?:create|update) secret (\S+) from vault secret `)
+	// syncSucceededLog matches the last log line of a successful sync.
+	syncSucceededLog = regexp.MustCompile(`(?m)\bsecrets successfully synchronized\b`)
+
+	errNoFullSync = errors.New("no succeeded sync job of the full secrets path found")
+)
+
+var pruneExample = `
+	# list the secrets the last full sync job did not synchronize and delete them after confirmation
+	%[1]s %[2]s prune
+
+	# delete the secrets without confirmation
+	%[1]s %[2]s prune --yes
+
+	# synchronize all vault secrets and prune the orphaned secrets afterwards
+	%[1]s %[2]s sync --wait --prune
+`
+
+// PruneOptions provides information required to delete the secrets whose
+// vault secret no longer exists.
+type PruneOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	userSpecifiedYes bool
+
+	rawConfig api.Config
+	args      []string
+
+	genericclioptions.IOStreams
+}
+
+// NewPruneOptions provides an instance of PruneOptions with default values
+func NewPruneOptions(streams genericclioptions.IOStreams) *PruneOptions {
+	o := &PruneOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdPrune provides a cobra command wrapping PruneOptions
+func NewCmdPrune(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdPrune(NewPruneOptions(streams))
+}
+
+// newCmdPrune provides a cobra command wrapping o.
+func newCmdPrune(o *PruneOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune [job]",
+		Short: "Delete the secrets whose vault secret no longer exists",
+		Long: "Delete the prefixed secrets in the namespace that the last succeeded sync job of the full secrets path (or the given job) " +
+			fmt.Sprintf("did not synchronize. Secrets with the label %s=false are never deleted.", pruneLabel),
+		Example:      fmt.Sprintf(pruneExample, "kubectl", Name),
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&o.userSpecifiedYes, "yes", "y", false,
+		"Delete the secrets without confirmation.")
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for pruning the secrets
+func (o *PruneOptions) Complete(cmd *cobra.Command, args []string) error {
+	o.args = args
+
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	return err
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *PruneOptions) Validate() error {
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run lists the orphaned secrets and deletes them after confirmation.
+func (o *PruneOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	syncJob, err := o.syncJob(ctx, clientset)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := prune(ctx, clientset, syncJob, o.userSpecifiedYes, o.In, o.Out)
+	for _, name := range deleted {
+		fmt.Fprintf(o.Out, "secret/%s deleted\n", name)
+	}
+
+	return err
+}
+
+// syncJob returns the job given as argument or the newest succeeded sync
+// job of the full secrets path.
+func (o *PruneOptions) syncJob(ctx context.Context, clientset kubernetes.Interface) (*batchv1.Job, error) {
+	if len(o.args) > 0 {
+		j, err := clientset.BatchV1().Jobs(o.currentNamespace).Get(ctx, o.args[0], metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not get job %s: %s", o.args[0], err)
+		}
+
+		return j, nil
+	}
+
+	jobs, err := vaultsync.ListJobs(ctx, clientset, o.currentNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range jobs {
+		if vaultsync.JobStatus(&jobs[i]) == vaultsync.StatusSucceeded && isFullSync(&jobs[i]) {
+			return &jobs[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w in namespace %s", errNoFullSync, o.currentNamespace)
+}
+
+// validatePrune validates the flags of the sync command used for pruning.
+func (o *SyncOptions) validatePrune() error {
+	if !o.userSpecifiedPrune {
+		return nil
+	}
+
+	switch {
+	case !o.userSpecifiedWait:
+		return errors.New("--prune requires --wait or --follow")
+	case o.userSpecifiedDryRun != dryRunNone:
+		return errors.New("--prune can not be used with --yaml or --dry-run")
+	case len(o.args) > 0:
+		return errors.New("--prune can not be used with a secret, it requires a sync of the full secrets path")
+	case (o.userSpecifiedAllNamespaces || o.userSpecifiedNamespaceSelector != "") && !o.userSpecifiedYes:
+		return errors.New("--prune requires --yes in combination with --all-namespaces or --namespace-selector")
+	}
+
+	return nil
+}
+
+// prune prunes the secrets after the sync job jobName in namespace has
+// succeeded.
+func (o *SyncOptions) prune(clientset kubernetes.Interface, namespace, jobName string, yes bool, out io.Writer) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	syncJob, err := clientset.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get job %s: %s", jobName, err)
+	}
+
+	return prune(ctx, clientset, syncJob, yes, o.In, out)
+}
+
+// prune lists the secrets syncJob did not synchronize on out and deletes
+// them, if yes is set or the user confirms on in. It returns the names of
+// the deleted secrets, the secrets deleted in the meantime are skipped.
+func prune(ctx context.Context, clientset kubernetes.Interface, syncJob *batchv1.Job, yes bool, in io.Reader, out io.Writer) ([]string, error) {
+	orphans, err := orphanedSecrets(ctx, clientset, syncJob)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orphans) == 0 {
+		fmt.Fprintf(out, "No orphaned secrets found in %s namespace.\n", syncJob.Namespace)
+		return nil, nil
+	}
+
+	if !yes {
+		fmt.Fprintf(out, "The following secrets were not synchronized by job %s:\n", syncJob.Name)
+
+		for _, name := range orphans {
+			fmt.Fprintf(out, "  %s\n", name)
+		}
+
+		if !confirm(in, out, fmt.Sprintf("Delete %d secrets in namespace %s?", len(orphans), syncJob.Namespace)) {
+			return nil, errors.New("pruning aborted")
+		}
+	}
+
+	deleted := []string{}
+
+	for _, name := range orphans {
+		err := clientset.CoreV1().Secrets(syncJob.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+
+		switch {
+		case apierrors.IsNotFound(err):
+			// the secret has been deleted in the meantime, e.g. by a concurrent prune
+			fmt.Fprintf(out, "secret/%s not found, skipped\n", name)
+		case err != nil:
+			return deleted, fmt.Errorf("could not delete secret %s: %s", name, err)
+		default:
+			deleted = append(deleted, name)
+		}
+	}
+
+	return deleted, nil
+}
+
+// orphanedSecrets returns the names of the secrets with the prefix of
+// syncJob that it did not synchronize. The secrets labeled with
+// pruneLabel=false, the secrets mounted by the job and the secrets created
+// after the job started are kept.
+func orphanedSecrets(ctx context.Context, clientset kubernetes.Interface, syncJob *batchv1.Job) ([]string, error) {
+	if vaultsync.JobStatus(syncJob) != vaultsync.StatusSucceeded {
+		return nil, fmt.Errorf("job %s has not succeeded", syncJob.Name)
+	}
+
+	if !isFullSync(syncJob) {
+		return nil, fmt.Errorf("job %s synchronized the single secret %s, pruning requires a sync of the full secrets path", syncJob.Name, jobSecrets(syncJob))
+	}
+
+	prefix := jobEnv(syncJob, "SECRET_PREFIX")
+	if prefix == "" {
+		return nil, fmt.Errorf("job %s synchronized the secrets without prefix, pruning requires a prefix", syncJob.Name)
+	}
+
+	synced, err := syncedSecrets(ctx, clientset, syncJob)
+	if err != nil {
+		return nil, err
+	}
+
+	mounted := map[string]bool{}
+
+	for _, v := range syncJob.Spec.Template.Spec.Volumes {
+		if v.Secret != nil {
+			mounted[v.Secret.SecretName] = true
+		}
+	}
+
+	secrets, err := clientset.CoreV1().Secrets(syncJob.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list secrets: %s", err)
+	}
+
+	orphans := []string{}
+
+	for _, s := range secrets.Items {
+		name := s.Name
+
+		switch {
+		case !strings.HasPrefix(name, prefix):
+		case synced[name] || synced[strings.TrimPrefix(name, prefix)]:
+		case s.Labels[pruneLabel] == "false":
+		case mounted[name]:
+		case syncJob.Status.StartTime != nil && syncJob.Status.StartTime.Before(&s.CreationTimestamp):
+		default:
+			orphans = append(orphans, name)
+		}
+	}
+
+	// without a synchronized secret, e.g. after a change of the log format,
+	// all prefixed secrets would be deleted
+	if len(synced) == 0 && len(orphans) > 0 {
+		return nil, fmt.Errorf("job %s reported no synchronized secrets, but %d secrets with prefix %s exist, refusing to prune", syncJob.Name, len(orphans), prefix)
+	}
+
+	sort.Strings(orphans)
+
+	return orphans, nil
+}
+
+// syncedSecrets returns the names of the secrets the synchronizer of
+// syncJob reported as synchronized.
+func syncedSecrets(ctx context.Context, clientset kubernetes.Interface, syncJob *batchv1.Job) (map[string]bool, error) {
+	pods, err := vaultsync.JobPods(ctx, clientset, syncJob.Namespace, syncJob.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var pod *v1.Pod
+
+	for i := range pods {
+		if pods[i].Status.Phase == v1.PodSucceeded {
+			pod = &pods[i]
+			break
+		}
+	}
+
+	if pod == nil {
+		return nil, fmt.Errorf("no succeeded pod found for job %s", syncJob.Name)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := streamContainerLogs(ctx, clientset, pod, syncContainerName, false, buf); err != nil {
+		return nil, err
+	}
+
+	if !syncSucceededLog.Match(buf.Bytes()) {
+		return nil, fmt.Errorf("could not find the synchronized secrets in the logs of pod %s", pod.Name)
+	}
+
+	synced := map[string]bool{}
+
+	for _, m := range syncedSecretLog.FindAllStringSubmatch(buf.String(), -1) {
+		synced[m[1]] = true
+	}
+
+	return synced, nil
+}
+
+// isFullSync returns true if j synchronizes the full secrets path.
+func isFullSync(j *batchv1.Job) bool {
+	return strings.HasSuffix(jobSecrets(j), "/")
+}
+
+// jobEnv returns the value of the environment variable name of the job's
+// synchronizer container.
+func jobEnv(j *batchv1.Job, name string) string {
+	for _, c := range j.Spec.Template.Spec.Containers {
+		if c.Name != syncContainerName {
+			continue
+		}
+
+		for _, e := range c.Env {
+			if e.Name == name {
+				return e.Value
+			}
+		}
+	}
+
+	return ""
+}
+
+// confirm asks question on out and returns true if the answer read from in
+// is yes.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,289 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const syncLogs = `2019/04/12 08:14:12 read secret/team/ from vault
+2019/04/12 08:14:12 update secret v3t-kept from vault secret secret/team/kept
+2019/04/12 08:14:12 create secret short from vault secret secret/team/short
+2019/04/12 08:14:12 secrets successfully synchronized
+`
+
+// newTestCmdPrune returns the prune command using clientset.
+func newTestCmdPrune(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewPruneOptions(streams)
+	o.clientset = clientset
+
+	return newCmdPrune(o)
+}
+
+// succeededPod returns the succeeded pod of job jobName.
+func succeededPod(jobName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName + "-abcde",
+			Namespace: testNamespace,
+			Labels:    map[string]string{"job-name": jobName},
+		},
+		Status: v1.PodStatus{Phase: v1.PodSucceeded},
+	}
+}
+
+// fullSyncJob returns a succeeded sync job of secretPath with the prefix
+// v3t- that started an hour ago.
+func fullSyncJob(name, secretPath string) *batchv1.Job {
+	j := job.New(
+		job.WithSecretPrefix("v3t-"),
+		job.WithVaultSecrets(secretPath),
+		job.WithTruststore("v3t-tls"),
+	)
+	s := syncJob(name, 2*time.Hour, vaultsync.StatusSucceeded)
+	s.Spec = j.Spec
+	s.Status.StartTime = &metav1.Time{Time: time.Now().Add(-time.Hour)}
+
+	return s
+}
+
+// prunedSecrets returns secrets in the test namespace for pruning tests.
+func prunedSecrets() []runtime.Object {
+	secret := func(name string, age time.Duration, labels map[string]string) *v1.Secret {
+		return &v1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         testNamespace,
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			Labels:            labels,
+		}}
+	}
+
+	return []runtime.Object{
+		secret("v3t-kept", 24*time.Hour, nil),
+		secret("v3t-short", 24*time.Hour, nil),
+		secret("v3t-orphan", 24*time.Hour, nil),
+		secret("v3t-other-orphan", 24*time.Hour, nil),
+		secret("v3t-protected", 24*time.Hour, map[string]string{pruneLabel: "false"}),
+		secret("v3t-tls", 24*time.Hour, nil),
+		secret("v3t-new", time.Minute, nil),
+		secret("unprefixed", 24*time.Hour, nil),
+	}
+}
+
+// secretNames returns the sorted names of the secrets in the test namespace.
+func secretNames(t *testing.T, clientset kubernetes.Interface) []string {
+	t.Helper()
+
+	secrets, err := clientset.CoreV1().Secrets(testNamespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, s := range secrets.Items {
+		names = append(names, s.Name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestPrune(t *testing.T) {
+	remaining := []string{"unprefixed", "v3t-kept", "v3t-new", "v3t-protected", "v3t-short", "v3t-tls"}
+	all := append([]string{"v3t-orphan", "v3t-other-orphan"}, remaining...)
+	sort.Strings(all)
+
+	var tt = []struct {
+		name              string
+		objects           []runtime.Object
+		logs              string
+		in                string
+		args              []string
+		expectedErr       string
+		expectedRemaining []string
+	}{
+		{
+			"yes",
+			[]runtime.Object{fullSyncJob("vault-sync-full", "secret/team/"), succeededPod("vault-sync-full")},
+			syncLogs,
+			"",
+			[]string{"--yes"},
+			"",
+			remaining,
+		},
+		{
+			"confirmed",
+			[]runtime.Object{fullSyncJob("vault-sync-full", "secret/team/"), succeededPod("vault-sync-full")},
+			syncLogs,
+			"y\n",
+			nil,
+			"",
+			remaining,
+		},
+		{
+			"declined",
+			[]runtime.Object{fullSyncJob("vault-sync-full", "secret/team/"), succeededPod("vault-sync-full")},
+			syncLogs,
+			"\n",
+			nil,
+			"pruning aborted",
+			all,
+		},
+		{
+			"newer single secret sync is skipped",
+			[]runtime.Object{
+				fullSyncJob("vault-sync-full", "secret/team/"), succeededPod("vault-sync-full"),
+				syncJob("vault-sync-single", time.Minute, vaultsync.StatusSucceeded),
+			},
+			syncLogs,
+			"",
+			[]string{"--yes"},
+			"",
+			remaining,
+		},
+		{
+			"scheduled job",
+			[]runtime.Object{scheduledJob(fullSyncJob("vault-sync-scheduled", "secret/team/")), succeededPod("vault-sync-scheduled")},
+			syncLogs,
+			"",
+			[]string{"--yes"},
+			"",
+			remaining,
+		},
+		{
+			"single secret job",
+			[]runtime.Object{fullSyncJob("vault-sync-single", "secret/team/single"), succeededPod("vault-sync-single")},
+			syncLogs,
+			"",
+			[]string{"--yes", "vault-sync-single"},
+			"pruning requires a sync of the full secrets path",
+			all,
+		},
+		{
+			"no full sync",
+			[]runtime.Object{syncJob("vault-sync-failed", time.Minute, vaultsync.StatusFailed)},
+			syncLogs,
+			"",
+			[]string{"--yes"},
+			errNoFullSync.Error(),
+			all,
+		},
+		{
+			"no synchronized secrets",
+			[]runtime.Object{fullSyncJob("vault-sync-full", "secret/team/"), succeededPod("vault-sync-full")},
+			"2019/04/12 08:14:12 secrets successfully synchronized\n",
+			"",
+			[]string{"--yes"},
+			"job vault-sync-full reported no synchronized secrets",
+			all,
+		},
+		{
+			"unknown logs",
+			[]runtime.Object{fullSyncJob("vault-sync-full", "secret/team/"), succeededPod("vault-sync-full")},
+			"fake logs",
+			"",
+			[]string{"--yes"},
+			"could not find the synchronized secrets",
+			all,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := logsClientset{newFakeClientset(append(prunedSecrets(), tc.objects...)...), staticLogs(tc.logs)}
+
+			out, err := runCommandWithInput(t, newTestCmdPrune, clientset, tc.in, tc.args...)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				assert.Contains(t, out, "secret/v3t-orphan deleted\n")
+			}
+
+			assert.Equal(t, tc.expectedRemaining, secretNames(t, clientset))
+		})
+	}
+}
+
+func TestPruneDeletedConcurrently(t *testing.T) {
+	fakeClientset := newFakeClientset(append(prunedSecrets(), fullSyncJob("vault-sync-full", "secret/team/"), succeededPod("vault-sync-full"))...)
+	clientset := logsClientset{fakeClientset, staticLogs(syncLogs)}
+
+	// another user deletes v3t-orphan after it has been listed
+	fakeClientset.PrependReactor("delete", "secrets", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.(k8stesting.DeleteAction).GetName() != "v3t-orphan" {
+			return false, nil, nil
+		}
+
+		return true, nil, apierrors.NewNotFound(v1.Resource("secrets"), "v3t-orphan")
+	})
+
+	out, err := runCommandWithInput(t, newTestCmdPrune, clientset, "", "--yes")
+	require.NoError(t, err)
+	assert.Contains(t, out, "secret/v3t-orphan not found, skipped\n")
+	assert.NotContains(t, out, "secret/v3t-orphan deleted")
+	assert.Contains(t, out, "secret/v3t-other-orphan deleted\n")
+}
+
+func TestSyncPrune(t *testing.T) {
+	fakeClientset := newFakeClientset(prunedSecrets()...)
+	clientset := logsClientset{fakeClientset, staticLogs(syncLogs)}
+
+	jobWatch := watch.NewFake()
+	fakeClientset.PrependWatchReactor("jobs", k8stesting.DefaultWatchReactor(jobWatch, nil))
+
+	// the job started before the secret v3t-new was created
+	fakeClientset.PrependReactor("create", "jobs", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		j := a.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		j.Status.StartTime = &metav1.Time{Time: time.Now().Add(-time.Hour)}
+
+		return false, nil, nil
+	})
+
+	go finishJob(t, fakeClientset, jobWatch, batchv1.JobComplete, succeededPod)
+
+	out, err := runCommand(t, newTestCmdSync, clientset, "--wait", "--prune", "--yes", "--vault-secret-prefix=v3t-", "--vault-trust-secret=v3t-tls")
+	require.NoError(t, err)
+	assert.Contains(t, out, "secret/v3t-orphan deleted\n")
+	assert.Equal(t, []string{"unprefixed", "v3t-kept", "v3t-new", "v3t-protected", "v3t-short", "v3t-tls"}, secretNames(t, clientset))
+}
+
+func TestSyncPruneValidate(t *testing.T) {
+	var tt = []struct {
+		name        string
+		args        []string
+		expectedErr string
+	}{
+		{"without wait", []string{"--prune"}, "--prune requires --wait or --follow"},
+		{"single secret", []string{"--prune", "--wait", "confidential"}, "requires a sync of the full secrets path"},
+		{"all namespaces", []string{"--prune", "--wait", "--all-namespaces"}, "--prune requires --yes"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := runCommand(t, newTestCmdSync, newFakeClientset(), tc.args...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
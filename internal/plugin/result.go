@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/printers"
+)
+
+const (
+	outputWide = "wide"
+	outputName = "name"
+
+	syncCreated = "Created"
+	syncSkipped = "Skipped"
+)
+
+// SyncResult is the outcome of the synchronization of one namespace. It is
+// printed with --output json|yaml.
+type SyncResult struct {
+	metav1.TypeMeta `json:",inline"`
+
+	JobName    string `json:"jobName,omitempty"`
+	Namespace  string `json:"namespace"`
+	SecretPath string `json:"secretPath,omitempty"`
+	Duration   string `json:"duration,omitempty"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+	// Pruned are the names of the secrets deleted by --prune.
+	Pruned []string `json:"pruned,omitempty"`
+}
+
+// newSyncResult creates a SyncResult for namespace with kind and apiVersion set.
+func newSyncResult(namespace string) *SyncResult {
+	return &SyncResult{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "sync.vault.postfinance.ch/v1",
+			Kind:       "SyncResult",
+		},
+		Namespace: namespace,
+	}
+}
+
+// DeepCopyObject implements runtime.Object.
+func (r *SyncResult) DeepCopyObject() runtime.Object {
+	c := *r
+	c.Pruned = append([]string(nil), r.Pruned...)
+
+	return &c
+}
+
+// fail sets the result's status to failed with err as reason.
+func (r *SyncResult) fail(err error) {
+	r.Status = vaultsync.StatusFailed
+	r.Reason = err.Error()
+}
+
+// humanOutput returns true if no machine readable output format is requested.
+func (o *SyncOptions) humanOutput() bool {
+	return o.outputFormat == "" || o.outputFormat == outputWide
+}
+
+// infof prints a progress message unless a machine readable output format is requested.
+func (o *SyncOptions) infof(format string, a ...interface{}) {
+	if o.humanOutput() {
+		fmt.Fprintf(o.Out, format, a...)
+	}
+}
+
+// printJob prints batchJob with the requested output format. With --wait
+// the job is printed together with the result (except for --output=name),
+// so that a single object is written to stdout.
+func (o *SyncOptions) printJob(batchJob *batchv1.Job) error {
+	if o.humanOutput() || (o.userSpecifiedWait && o.outputFormat != outputName) {
+		return nil
+	}
+
+	return o.printer.PrintObj(batchJob, o.Out)
+}
+
+// printResults prints the results as table or with the requested output format
+// and returns an error if the synchronization failed in at least one namespace.
+func (o *SyncOptions) printResults(results []*SyncResult) error {
+	failed := 0
+
+	for _, r := range results {
+		if r.Status == vaultsync.StatusFailed {
+			failed++
+		}
+	}
+
+	if o.humanOutput() {
+		o.printResultTable(results)
+	} else {
+		list, err := resultList(results)
+		if err != nil {
+			return err
+		}
+
+		if err := o.printer.PrintObj(list, o.Out); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("synchronization failed in %d of %d namespaces", failed, len(results))
+	}
+
+	return nil
+}
+
+// resultList returns the results as list, so that they are printed as a
+// single object.
+func resultList(results []*SyncResult) (*v1.List, error) {
+	list := &v1.List{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "List",
+		},
+	}
+
+	for _, r := range results {
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode result of namespace %s: %s", r.Namespace, err)
+		}
+
+		list.Items = append(list.Items, runtime.RawExtension{Raw: raw})
+	}
+
+	return list, nil
+}
+
+// jobResultList returns the created job and the result of its
+// synchronization as list, so that they are printed as a single object.
+func jobResultList(batchJob *batchv1.Job, r *SyncResult) (*v1.List, error) {
+	// objects returned by the API server have no kind and apiVersion
+	j := batchJob.DeepCopy()
+	j.SetGroupVersionKind(batchv1.SchemeGroupVersion.WithKind("Job"))
+
+	rawJob, err := json.Marshal(j)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode job %s: %s", j.Name, err)
+	}
+
+	rawResult, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode result of namespace %s: %s", r.Namespace, err)
+	}
+
+	return &v1.List{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "List",
+		},
+		Items: []runtime.RawExtension{{Raw: rawJob}, {Raw: rawResult}},
+	}, nil
+}
+
+// printResult prints the job batchJob and the result of the synchronization
+// of a single namespace.
+func (o *SyncOptions) printResult(batchJob *batchv1.Job, r *SyncResult) error {
+	switch o.outputFormat {
+	case "", outputName:
+		return nil
+	case outputWide:
+		o.printResultTable([]*SyncResult{r})
+		return nil
+	default:
+		list, err := jobResultList(batchJob, r)
+		if err != nil {
+			return err
+		}
+
+		return o.printer.PrintObj(list, o.Out)
+	}
+}
+
+// logWriter returns the writer for streamed job logs. Logs are written to
+// stderr if a machine readable output format is requested.
+func (o *SyncOptions) logWriter() io.Writer {
+	if o.humanOutput() {
+		return o.Out
+	}
+
+	return o.ErrOut
+}
+
+func (o *SyncOptions) printResultTable(results []*SyncResult) {
+	w := printers.GetNewTabWriter(o.Out)
+	defer w.Flush()
+
+	wide := o.outputFormat == outputWide
+
+	if wide {
+		fmt.Fprintln(w, "NAMESPACE\tJOB\tSECRETS\tRESULT\tDURATION\tMESSAGE")
+	} else {
+		fmt.Fprintln(w, "NAMESPACE\tJOB\tSECRETS\tRESULT\tMESSAGE")
+	}
+
+	for _, r := range results {
+		if wide {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.Namespace, valueOrNone(r.JobName), valueOrNone(r.SecretPath), r.Status, valueOrNone(r.Duration), r.Reason)
+
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Namespace, valueOrNone(r.JobName), valueOrNone(r.SecretPath), r.Status, r.Reason)
+	}
+}
+
+func since(start time.Time) string {
+	return time.Since(start).Round(time.Second).String()
+}
+
+func valueOrNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+
+	return s
+}
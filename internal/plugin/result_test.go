@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+// decodeObject decodes out, that must contain exactly one json object.
+func decodeObject(t *testing.T, out string) map[string]interface{} {
+	t.Helper()
+
+	obj := map[string]interface{}{}
+	dec := json.NewDecoder(strings.NewReader(out))
+	require.NoError(t, dec.Decode(&obj))
+	require.False(t, dec.More(), "only one object must be printed")
+
+	return obj
+}
+
+// decodeYAMLObject decodes out, that must contain exactly one yaml document.
+func decodeYAMLObject(t *testing.T, out string) map[string]interface{} {
+	t.Helper()
+
+	require.NotContains(t, out, "---", "only one object must be printed")
+
+	data, err := yaml.YAMLToJSON([]byte(out))
+	require.NoError(t, err)
+
+	return decodeObject(t, string(data))
+}
+
+// listItems returns the job and the result of list, that must contain
+// exactly these two items.
+func listItems(t *testing.T, list map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+
+	require.Equal(t, "List", list["kind"])
+
+	items, ok := list["items"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, items, 2)
+
+	return items[0].(map[string]interface{}), items[1].(map[string]interface{})
+}
+
+func TestSyncOutput(t *testing.T) {
+	var tt = []struct {
+		name   string
+		args   []string
+		verify func(t *testing.T, out string)
+	}{
+		{
+			"human",
+			[]string{"--wait"},
+			func(t *testing.T, out string) {
+				assert.Contains(t, out, "creating sync batch job to synchronize 'secret/annotation/' vault key\n")
+				assert.Regexp(t, `sync batch job vault-sync-\S+ succeeded after`, out)
+			},
+		},
+		{
+			"wide",
+			[]string{"--wait", "-o", "wide"},
+			func(t *testing.T, out string) {
+				rows := resultRows(out)
+				require.Contains(t, rows, testNamespace)
+				assert.Equal(t, "secret/annotation/", rows[testNamespace][1])
+				assert.Equal(t, vaultsync.StatusSucceeded, rows[testNamespace][2])
+				assert.Contains(t, out, "DURATION")
+			},
+		},
+		{
+			"name",
+			[]string{"--wait", "-o", "name"},
+			func(t *testing.T, out string) {
+				assert.Regexp(t, `^job.batch/vault-sync-\S+\n$`, out)
+			},
+		},
+		{
+			"json",
+			[]string{"--wait", "-o", "json"},
+			func(t *testing.T, out string) {
+				batchJob, r := listItems(t, decodeObject(t, out))
+				assert.Equal(t, "Job", batchJob["kind"])
+				assert.Equal(t, "batch/v1", batchJob["apiVersion"])
+				assert.Equal(t, "SyncResult", r["kind"])
+				assert.Equal(t, testNamespace, r["namespace"])
+				assert.Equal(t, vaultsync.StatusSucceeded, r["status"])
+				assert.Contains(t, r["jobName"], vaultsync.JobName)
+				assert.Equal(t, r["jobName"], batchJob["metadata"].(map[string]interface{})["name"])
+			},
+		},
+		{
+			"yaml",
+			[]string{"--wait", "-o", "yaml"},
+			func(t *testing.T, out string) {
+				batchJob, r := listItems(t, decodeYAMLObject(t, out))
+				assert.Equal(t, "Job", batchJob["kind"])
+				assert.Equal(t, "SyncResult", r["kind"])
+				assert.Equal(t, vaultsync.StatusSucceeded, r["status"])
+			},
+		},
+		{
+			"json without wait",
+			[]string{"-o", "json"},
+			func(t *testing.T, out string) {
+				obj := decodeObject(t, out)
+				assert.Equal(t, "Job", obj["kind"])
+			},
+		},
+		{
+			"jsonpath",
+			[]string{"--wait", "-o", "jsonpath={.items[1].status}"},
+			func(t *testing.T, out string) {
+				assert.Equal(t, vaultsync.StatusSucceeded, out)
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset()
+			jobWatch := watch.NewFake()
+			clientset.PrependWatchReactor("jobs", k8stesting.DefaultWatchReactor(jobWatch, nil))
+
+			go finishJob(t, clientset, jobWatch, batchv1.JobComplete, nil)
+
+			out, err := runCommand(t, newTestCmdSync, clientset, append(tc.args, "--timeout=5s")...)
+			require.NoError(t, err)
+
+			tc.verify(t, out)
+		})
+	}
+}
+
+func TestSyncNamespacesOutput(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		clientset := newFakeClientset(teamNamespace("team-a", "a", configuredAnnotations))
+
+		out, err := runCommand(t, newTestCmdSync, clientset, "--all-namespaces", "-o", format)
+		require.NoError(t, err)
+
+		var list map[string]interface{}
+		if format == "json" {
+			list = decodeObject(t, out)
+		} else {
+			list = decodeYAMLObject(t, out)
+		}
+
+		assert.Equal(t, "List", list["kind"], format)
+
+		items, ok := list["items"].([]interface{})
+		require.True(t, ok, format)
+		require.Len(t, items, 2, format)
+
+		namespaces := []string{}
+		for _, item := range items {
+			r := item.(map[string]interface{})
+			assert.Equal(t, "SyncResult", r["kind"], format)
+			assert.Equal(t, syncCreated, r["status"], format)
+			namespaces = append(namespaces, r["namespace"].(string))
+		}
+
+		assert.ElementsMatch(t, []string{"team-a", testNamespace}, namespaces, format)
+	}
+}
+
+func TestSyncResultDeepCopy(t *testing.T) {
+	r := newSyncResult(testNamespace)
+	r.Pruned = []string{"v3t-orphan"}
+
+	c := r.DeepCopyObject().(*SyncResult)
+	c.Pruned[0] = "changed"
+
+	assert.Equal(t, "v3t-orphan", r.Pruned[0], "the copy must not share the pruned secrets")
+	assert.Equal(t, "SyncResult", c.Kind)
+}
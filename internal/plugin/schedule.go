@@ -0,0 +1,306 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	dfltSuccessfulJobsHistoryLimit = 3
+	dfltFailedJobsHistoryLimit     = 1
+)
+
+var scheduleExample = `
+	# synchronize all vault secrets every 15 minutes (creates or updates the cron job)
+	%[1]s %[2]s schedule --schedule '*/15 * * * *'
+
+	# synchronize the vault secret 'confidential' every night at 2 o'clock
+	%[1]s %[2]s schedule confidential --schedule '0 2 * * *'
+
+	# suspend and resume the scheduled synchronization
+	%[1]s %[2]s schedule --suspend
+	%[1]s %[2]s schedule --resume
+
+	# delete the scheduled synchronization
+	%[1]s %[2]s schedule --delete
+
+	# view the cron job as yaml (this creates no cron job)
+	%[1]s %[2]s schedule --schedule '@hourly' --yaml
+`
+
+// ScheduleOptions provides information required to manage the cron job that
+// synchronizes vault secrets periodically.
+type ScheduleOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	jobOptions
+
+	userSpecifiedSchedule                   string
+	userSpecifiedConcurrencyPolicy          string
+	userSpecifiedSuccessfulJobsHistoryLimit int32
+	userSpecifiedFailedJobsHistoryLimit     int32
+	userSpecifiedSuspend                    bool
+	userSpecifiedResume                     bool
+	userSpecifiedDelete                     bool
+	userSpecifiedYAML                       bool
+
+	rawConfig api.Config
+	args      []string
+
+	genericclioptions.IOStreams
+}
+
+// NewScheduleOptions provides an instance of ScheduleOptions with default values
+func NewScheduleOptions(streams genericclioptions.IOStreams) *ScheduleOptions {
+	o := &ScheduleOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdSchedule provides a cobra command wrapping ScheduleOptions
+func NewCmdSchedule(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdSchedule(NewScheduleOptions(streams))
+}
+
+// newCmdSchedule provides a cobra command wrapping o.
+func newCmdSchedule(o *ScheduleOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "schedule [secret]",
+		Short:        "Create, update, suspend, resume or delete a cron job that synchronizes vault secrets periodically",
+		Example:      fmt.Sprintf(scheduleExample, "kubectl", Name),
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	o.jobOptions.addFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.userSpecifiedSchedule, "schedule", "",
+		"The schedule in cron format, e.g. '*/15 * * * *'. Required to create or update the cron job.")
+	cmd.Flags().StringVar(&o.userSpecifiedConcurrencyPolicy, "concurrency-policy", string(batchv1.ForbidConcurrent),
+		"How to treat concurrent executions of the sync job. One of: Allow, Forbid, Replace.")
+	cmd.Flags().Int32Var(&o.userSpecifiedSuccessfulJobsHistoryLimit, "successful-jobs-history-limit", dfltSuccessfulJobsHistoryLimit,
+		"The number of successful finished sync jobs to keep.")
+	cmd.Flags().Int32Var(&o.userSpecifiedFailedJobsHistoryLimit, "failed-jobs-history-limit", dfltFailedJobsHistoryLimit,
+		"The number of failed finished sync jobs to keep.")
+	cmd.Flags().BoolVar(&o.userSpecifiedSuspend, "suspend", false,
+		"Suspend subsequent executions of the cron job.")
+	cmd.Flags().BoolVar(&o.userSpecifiedResume, "resume", false,
+		"Resume a suspended cron job.")
+	cmd.Flags().BoolVar(&o.userSpecifiedDelete, "delete", false,
+		"Delete the cron job.")
+	cmd.Flags().BoolVar(&o.userSpecifiedYAML, "yaml", false,
+		"Print cron job yaml to stdout without creating the cron job.")
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for managing the cron job
+func (o *ScheduleOptions) Complete(cmd *cobra.Command, args []string) error {
+	o.args = args
+
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadProfile(o.configFlags, &o.rawConfig); err != nil {
+		return err
+	}
+
+	return o.loadPatchFile()
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *ScheduleOptions) Validate() error {
+	var err error
+
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+	if err != nil {
+		return err
+	}
+
+	if o.userSpecifiedSuspend && o.userSpecifiedResume {
+		return errors.New("--suspend can not be used with --resume")
+	}
+
+	if o.userSpecifiedDelete && (o.userSpecifiedSchedule != "" || o.userSpecifiedSuspend || o.userSpecifiedResume || o.userSpecifiedYAML) {
+		return errors.New("--delete can not be used with --schedule, --suspend, --resume or --yaml")
+	}
+
+	if o.userSpecifiedSchedule == "" && !o.userSpecifiedDelete && !o.userSpecifiedSuspend && !o.userSpecifiedResume {
+		return errors.New("--schedule is required to create or update the cron job")
+	}
+
+	if o.userSpecifiedYAML && o.userSpecifiedSchedule == "" {
+		return errors.New("--schedule is required in combination with --yaml")
+	}
+
+	switch batchv1.ConcurrencyPolicy(o.userSpecifiedConcurrencyPolicy) {
+	case batchv1.AllowConcurrent, batchv1.ForbidConcurrent, batchv1.ReplaceConcurrent:
+	default:
+		return fmt.Errorf("invalid concurrency policy %q: must be one of Allow, Forbid, Replace", o.userSpecifiedConcurrencyPolicy)
+	}
+
+	if o.userSpecifiedSuccessfulJobsHistoryLimit < 0 || o.userSpecifiedFailedJobsHistoryLimit < 0 {
+		return errors.New("history limits must not be negative")
+	}
+
+	// finished jobs of the cron job are deleted according to its history limits
+	for _, name := range []string{"ttl", "keep-successful", "keep-failed"} {
+		if o.flags.Changed(name) {
+			return fmt.Errorf("--%s can not be used with schedule, use --successful-jobs-history-limit and --failed-jobs-history-limit", name)
+		}
+	}
+
+	return nil
+}
+
+// Run creates, updates, suspends, resumes or deletes the cron job.
+func (o *ScheduleOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadClusterDefaults(clientset, o.ErrOut); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	cronClient := clientset.BatchV1().CronJobs(o.currentNamespace)
+
+	switch {
+	case o.userSpecifiedDelete:
+		if err := cronClient.Delete(ctx, job.Name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("could not delete cron job %s: %s", job.Name, err)
+		}
+
+		fmt.Fprintf(o.Out, "cronjob.batch/%s deleted\n", job.Name)
+
+		return nil
+	case o.userSpecifiedSchedule == "":
+		return o.setSuspend(ctx, clientset)
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, o.currentNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get namespace %s: %s", o.currentNamespace, err)
+	}
+
+	b := &vaultsync.Builder{}
+	if len(o.args) > 0 {
+		b.Secret = o.args[0]
+	}
+
+	batchJob, _, err := o.jobOptions.newJob(ctx, clientset, ns, b)
+	if err != nil {
+		return err
+	}
+
+	// finished jobs of the cron job are deleted according to its history limits
+	batchJob.Spec.TTLSecondsAfterFinished = nil
+
+	cronJob := job.NewCronJob(o.userSpecifiedSchedule, batchJob,
+		job.WithConcurrencyPolicy(batchv1.ConcurrencyPolicy(o.userSpecifiedConcurrencyPolicy)),
+		job.WithHistoryLimits(o.userSpecifiedSuccessfulJobsHistoryLimit, o.userSpecifiedFailedJobsHistoryLimit),
+		job.WithSuspend(o.userSpecifiedSuspend),
+	)
+
+	if o.userSpecifiedYAML {
+		p := printers.NewTypeSetter(scheme.Scheme).ToPrinter(&printers.YAMLPrinter{})
+		return p.PrintObj(cronJob, o.Out)
+	}
+
+	existing, err := cronClient.Get(ctx, job.Name, metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+		if _, err := cronClient.Create(ctx, cronJob, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("could not create cron job %s: %s", cronJob.Name, err)
+		}
+
+		fmt.Fprintf(o.Out, "cronjob.batch/%s created\n", cronJob.Name)
+	case err != nil:
+		return fmt.Errorf("could not get cron job %s: %s", cronJob.Name, err)
+	default:
+		// a suspended cron job stays suspended unless --resume is given
+		if !o.userSpecifiedSuspend && !o.userSpecifiedResume {
+			cronJob.Spec.Suspend = existing.Spec.Suspend
+		}
+
+		existing.Labels = cronJob.Labels
+		existing.Spec = cronJob.Spec
+
+		if _, err := cronClient.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("could not update cron job %s: %s", cronJob.Name, err)
+		}
+
+		fmt.Fprintf(o.Out, "cronjob.batch/%s configured\n", cronJob.Name)
+	}
+
+	return nil
+}
+
+// setSuspend suspends or resumes the existing cron job.
+func (o *ScheduleOptions) setSuspend(ctx context.Context, clientset kubernetes.Interface) error {
+	cronClient := clientset.BatchV1().CronJobs(o.currentNamespace)
+
+	cronJob, err := cronClient.Get(ctx, job.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get cron job %s: %s", job.Name, err)
+	}
+
+	suspend := o.userSpecifiedSuspend
+	cronJob.Spec.Suspend = &suspend
+
+	if _, err := cronClient.Update(ctx, cronJob, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update cron job %s: %s", job.Name, err)
+	}
+
+	action := "resumed"
+	if suspend {
+		action = "suspended"
+	}
+
+	fmt.Fprintf(o.Out, "cronjob.batch/%s %s\n", job.Name, action)
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// newTestCmdSchedule returns the schedule command using clientset.
+func newTestCmdSchedule(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewScheduleOptions(streams)
+	o.clientset = clientset
+
+	return newCmdSchedule(o)
+}
+
+// existingCronJob returns a cron job of the schedule command.
+func existingCronJob(schedule string, suspend bool) *batchv1.CronJob {
+	c := job.NewCronJob(schedule, &batchv1.Job{}, job.WithSuspend(suspend))
+	c.Namespace = testNamespace
+
+	return c
+}
+
+// getCronJob returns the cron job of the schedule command.
+func getCronJob(t *testing.T, clientset kubernetes.Interface) *batchv1.CronJob {
+	t.Helper()
+
+	c, err := clientset.BatchV1().CronJobs(testNamespace).Get(context.Background(), job.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestScheduleCreate(t *testing.T) {
+	clientset := newFakeClientset()
+
+	out, err := runCommand(t, newTestCmdSchedule, clientset, "confidential", "--schedule", "*/15 * * * *",
+		"--concurrency-policy=Replace", "--successful-jobs-history-limit=5", "--failed-jobs-history-limit=2")
+	require.NoError(t, err)
+	assert.Equal(t, "cronjob.batch/"+job.Name+" created\n", out)
+
+	c := getCronJob(t, clientset)
+	assert.Equal(t, "*/15 * * * *", c.Spec.Schedule)
+	assert.Equal(t, batchv1.ReplaceConcurrent, c.Spec.ConcurrencyPolicy)
+	assert.Equal(t, int32(5), *c.Spec.SuccessfulJobsHistoryLimit)
+	assert.Equal(t, int32(2), *c.Spec.FailedJobsHistoryLimit)
+	assert.False(t, *c.Spec.Suspend)
+
+	spec := c.Spec.JobTemplate.Spec
+	assert.Nil(t, spec.TTLSecondsAfterFinished, "finished jobs are deleted according to the history limits")
+	assert.Contains(t, spec.Template.Spec.Containers[0].Env, v1.EnvVar{Name: "VAULT_SECRETS", Value: "secret/annotation/confidential"})
+	assert.Equal(t, "annotation-sync-image", spec.Template.Spec.Containers[0].Image)
+}
+
+func TestScheduleUpdate(t *testing.T) {
+	clientset := newFakeClientset(existingCronJob("@daily", false))
+
+	out, err := runCommand(t, newTestCmdSchedule, clientset, "--schedule", "@hourly")
+	require.NoError(t, err)
+	assert.Equal(t, "cronjob.batch/"+job.Name+" configured\n", out)
+
+	c := getCronJob(t, clientset)
+	assert.Equal(t, "@hourly", c.Spec.Schedule)
+	assert.Contains(t, c.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Env, v1.EnvVar{Name: "VAULT_SECRETS", Value: "secret/annotation/"})
+}
+
+func TestScheduleSuspendResume(t *testing.T) {
+	var tt = []struct {
+		name            string
+		suspended       bool
+		args            []string
+		expectedOut     string
+		expectedSuspend bool
+	}{
+		{"suspend", false, []string{"--suspend"}, "suspended", true},
+		{"resume", true, []string{"--resume"}, "resumed", false},
+		{"update and suspend", false, []string{"--schedule", "@hourly", "--suspend"}, "configured", true},
+		{"update and resume", true, []string{"--schedule", "@hourly", "--resume"}, "configured", false},
+		{"update suspended", true, []string{"--schedule", "@hourly"}, "configured", true},
+		{"update resumed", false, []string{"--schedule", "@hourly"}, "configured", false},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset(existingCronJob("@daily", tc.suspended))
+
+			out, err := runCommand(t, newTestCmdSchedule, clientset, tc.args...)
+			require.NoError(t, err)
+			assert.Equal(t, "cronjob.batch/"+job.Name+" "+tc.expectedOut+"\n", out)
+			assert.Equal(t, tc.expectedSuspend, *getCronJob(t, clientset).Spec.Suspend)
+		})
+	}
+
+	_, err := runCommand(t, newTestCmdSchedule, newFakeClientset(), "--suspend")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not get cron job")
+}
+
+func TestScheduleDelete(t *testing.T) {
+	clientset := newFakeClientset(existingCronJob("@daily", false))
+
+	out, err := runCommand(t, newTestCmdSchedule, clientset, "--delete")
+	require.NoError(t, err)
+	assert.Equal(t, "cronjob.batch/"+job.Name+" deleted\n", out)
+
+	_, err = clientset.BatchV1().CronJobs(testNamespace).Get(context.Background(), job.Name, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	_, err = runCommand(t, newTestCmdSchedule, clientset, "--delete")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not delete cron job")
+}
+
+func TestScheduleYAML(t *testing.T) {
+	clientset := newFakeClientset()
+
+	out, err := runCommand(t, newTestCmdSchedule, clientset, "--schedule", "@hourly", "--yaml")
+	require.NoError(t, err)
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(out), nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &batchv1.CronJob{}, obj)
+	assert.Equal(t, "@hourly", obj.(*batchv1.CronJob).Spec.Schedule)
+
+	cronJobs, err := clientset.BatchV1().CronJobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, cronJobs.Items, "--yaml must not create a cron job")
+}
+
+func TestScheduleValidate(t *testing.T) {
+	var tt = []struct {
+		name        string
+		args        []string
+		expectedErr string
+	}{
+		{"no action", nil, "--schedule is required"},
+		{"suspend and resume", []string{"--suspend", "--resume"}, "--suspend can not be used with --resume"},
+		{"delete and schedule", []string{"--delete", "--schedule", "@hourly"}, "--delete can not be used"},
+		{"delete and suspend", []string{"--delete", "--suspend"}, "--delete can not be used"},
+		{"delete and yaml", []string{"--delete", "--yaml"}, "--delete can not be used"},
+		{"yaml without schedule", []string{"--yaml", "--suspend"}, "--schedule is required in combination with --yaml"},
+		{"concurrency policy", []string{"--schedule", "@hourly", "--concurrency-policy=Never"}, "invalid concurrency policy"},
+		{"history limit", []string{"--schedule", "@hourly", "--failed-jobs-history-limit=-1"}, "history limits must not be negative"},
+		{"ttl", []string{"--schedule", "@hourly", "--ttl=1h"}, "--ttl can not be used with schedule"},
+		{"keep failed", []string{"--schedule", "@hourly", "--keep-failed=3"}, "--keep-failed can not be used with schedule"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := runCommand(t, newTestCmdSchedule, newFakeClientset(), tc.args...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
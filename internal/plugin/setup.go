@@ -0,0 +1,558 @@
+package plugin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	dfltSetupTrustSecret = "vault-tls"
+	// setupRoleName is the name of the role and the role binding that grant
+	// the service account of the sync job access to secrets.
+	setupRoleName = "vault-sync"
+
+	actionCreated    = "created"
+	actionConfigured = "configured"
+	actionUnchanged  = "unchanged"
+)
+
+var setupExample = `
+	# configure the current namespace for synchronization
+	%[1]s %[2]s setup --vault-role my-role --vault-addr https://vault.example.com --vault-secretspath secret/team/k8s --ca-file ca.pem
+
+	# show the changes without applying them
+	%[1]s %[2]s setup --vault-role my-role --vault-addr https://vault.example.com --vault-secretspath secret/team/k8s --dry-run=server
+
+	# print the manifests, e.g. for a GitOps repository
+	%[1]s %[2]s setup --vault-role my-role --vault-addr https://vault.example.com --vault-secretspath secret/team/k8s --ca-file ca.pem --dry-run=client -o yaml
+`
+
+// SetupOptions provides information required to onboard a namespace: the
+// namespace annotations, the service account of the sync job, its access
+// to secrets and the truststore secret.
+type SetupOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	userSpecifiedVaultRole        string
+	userSpecifiedVaultAddr        string
+	userSpecifiedVaultSecretsPath string
+	userSpecifiedVaultMountpath   string
+	userSpecifiedVaultTrustSecret string
+	userSpecifiedCAFile           string
+	userSpecifiedDryRun           string
+
+	ca []byte
+
+	printFlags *genericclioptions.PrintFlags
+	printer    printers.ResourcePrinter
+
+	rawConfig api.Config
+
+	genericclioptions.IOStreams
+}
+
+// NewSetupOptions provides an instance of SetupOptions with default values
+func NewSetupOptions(streams genericclioptions.IOStreams) *SetupOptions {
+	o := &SetupOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		printFlags:  genericclioptions.NewPrintFlags("").WithTypeSetter(scheme.Scheme),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdSetup provides a cobra command wrapping SetupOptions
+func NewCmdSetup(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdSetup(NewSetupOptions(streams))
+}
+
+// newCmdSetup provides a cobra command wrapping o.
+func newCmdSetup(o *SetupOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "setup",
+		Short:        "Configure a namespace for synchronization: annotations, service account, role and truststore secret",
+		Example:      fmt.Sprintf(setupExample, "kubectl", Name),
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	cmd.Flags().StringVar(&o.userSpecifiedVaultRole, "vault-role", "",
+		fmt.Sprintf("Name of the vault role to use for authentication (annotation '%s').", vaultsync.AnnotationRole))
+	cmd.Flags().StringVar(&o.userSpecifiedVaultAddr, "vault-addr", "",
+		fmt.Sprintf("The URL of the vault server (annotation '%s').", vaultsync.AnnotationAddr))
+	cmd.Flags().StringVar(&o.userSpecifiedVaultSecretsPath, "vault-secretspath", "",
+		fmt.Sprintf("Secrets path in vault (annotation '%s').", vaultsync.AnnotationSecretsPath))
+	cmd.Flags().StringVar(&o.userSpecifiedVaultMountpath, "vault-mountpath", "",
+		fmt.Sprintf("Name of the mount path where the Kubernetes auth method is enabled (annotation '%s'). If not set, the annotation is not changed.", vaultsync.AnnotationMountPath))
+	cmd.Flags().StringVar(&o.userSpecifiedCAFile, "ca-file", "",
+		fmt.Sprintf("A PEM file with the CA certificates to connect to vault. It is stored with key '%s' in the secret given with --vault-trust-secret.", job.TruststoreKey))
+	cmd.Flags().StringVar(&o.userSpecifiedVaultTrustSecret, "vault-trust-secret", dfltSetupTrustSecret,
+		fmt.Sprintf("The secret for the CA certificates of --ca-file (annotation '%s').", vaultsync.AnnotationTrustSecret))
+	cmd.Flags().StringVar(&o.userSpecifiedDryRun, "dry-run", dryRunNone,
+		`Must be "none", "server", or "client". If client strategy, only print the objects that would be created or updated. `+
+			`If server strategy, submit the changes to the API server without persisting them.`)
+	o.printFlags.AddFlags(cmd)
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for configuring the namespace
+func (o *SetupOptions) Complete(cmd *cobra.Command, args []string) error {
+	var err error
+
+	switch o.userSpecifiedDryRun {
+	case dryRunNone, dryRunClient, dryRunServer:
+	default:
+		return fmt.Errorf(`invalid dry-run value (%s). Must be "none", "server", or "client"`, o.userSpecifiedDryRun)
+	}
+
+	if *o.printFlags.OutputFormat != "" {
+		o.printer, err = o.printFlags.ToPrinter()
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.userSpecifiedCAFile != "" {
+		o.ca, err = os.ReadFile(o.userSpecifiedCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read CA file: %s", err)
+		}
+	}
+
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	return err
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *SetupOptions) Validate() error {
+	if o.userSpecifiedVaultRole == "" || o.userSpecifiedVaultAddr == "" || o.userSpecifiedVaultSecretsPath == "" {
+		return errors.New("--vault-role, --vault-addr and --vault-secretspath are required")
+	}
+
+	if o.ca != nil {
+		if err := validateCA(o.ca); err != nil {
+			return fmt.Errorf("invalid CA file %s: %s", o.userSpecifiedCAFile, err)
+		}
+
+		if o.userSpecifiedVaultTrustSecret == "" {
+			return errors.New("--vault-trust-secret is required with --ca-file")
+		}
+	}
+
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run creates or updates the objects of the namespace. Existing objects are
+// only updated if they differ, and the difference is printed.
+func (o *SetupOptions) Run() error {
+	resources := o.resources()
+
+	if o.userSpecifiedDryRun == dryRunClient {
+		for _, r := range resources {
+			if err := o.print(r.desired, "created (dry run)"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	for _, r := range resources {
+		if err := o.apply(ctx, clientset, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setupResource is an object managed by the setup command.
+type setupResource struct {
+	kind    string
+	desired runtime.Object
+	// get returns the existing object.
+	get func(ctx context.Context, clientset kubernetes.Interface) (runtime.Object, error)
+	// create creates the desired object. Objects without create, like the
+	// namespace, must exist.
+	create func(ctx context.Context, clientset kubernetes.Interface, opts metav1.CreateOptions) (runtime.Object, error)
+	// update updates existing with the desired settings.
+	update func(ctx context.Context, clientset kubernetes.Interface, existing runtime.Object, opts metav1.UpdateOptions) (runtime.Object, error)
+	// merge returns a copy of existing with the desired settings.
+	merge func(existing runtime.Object) runtime.Object
+}
+
+// resources returns the objects to create or update.
+func (o *SetupOptions) resources() []setupResource {
+	ns := o.currentNamespace
+
+	annotations := map[string]string{
+		vaultsync.AnnotationRole:        o.userSpecifiedVaultRole,
+		vaultsync.AnnotationAddr:        o.userSpecifiedVaultAddr,
+		vaultsync.AnnotationSecretsPath: o.userSpecifiedVaultSecretsPath,
+	}
+	if o.userSpecifiedVaultMountpath != "" {
+		annotations[vaultsync.AnnotationMountPath] = o.userSpecifiedVaultMountpath
+	}
+
+	if o.ca != nil {
+		annotations[vaultsync.AnnotationTrustSecret] = o.userSpecifiedVaultTrustSecret
+	}
+
+	resources := []setupResource{
+		{
+			kind: "namespace",
+			desired: &v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: ns, Annotations: annotations},
+			},
+			get: func(ctx context.Context, clientset kubernetes.Interface) (runtime.Object, error) {
+				return clientset.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+			},
+			update: func(ctx context.Context, clientset kubernetes.Interface, obj runtime.Object, opts metav1.UpdateOptions) (runtime.Object, error) {
+				return clientset.CoreV1().Namespaces().Update(ctx, obj.(*v1.Namespace), opts)
+			},
+			merge: func(existing runtime.Object) runtime.Object {
+				n := existing.DeepCopyObject().(*v1.Namespace)
+				if n.Annotations == nil {
+					n.Annotations = map[string]string{}
+				}
+
+				for k, v := range annotations {
+					n.Annotations[k] = v
+				}
+
+				return n
+			},
+		},
+		{
+			kind: "serviceaccount",
+			desired: &v1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: job.ServiceAccountName, Namespace: ns},
+			},
+			get: func(ctx context.Context, clientset kubernetes.Interface) (runtime.Object, error) {
+				return clientset.CoreV1().ServiceAccounts(ns).Get(ctx, job.ServiceAccountName, metav1.GetOptions{})
+			},
+			create: func(ctx context.Context, clientset kubernetes.Interface, opts metav1.CreateOptions) (runtime.Object, error) {
+				return clientset.CoreV1().ServiceAccounts(ns).Create(ctx, &v1.ServiceAccount{
+					ObjectMeta: metav1.ObjectMeta{Name: job.ServiceAccountName},
+				}, opts)
+			},
+			merge: func(existing runtime.Object) runtime.Object {
+				return existing.DeepCopyObject()
+			},
+		},
+		{
+			kind:    "role.rbac.authorization.k8s.io",
+			desired: o.role(),
+			get: func(ctx context.Context, clientset kubernetes.Interface) (runtime.Object, error) {
+				return clientset.RbacV1().Roles(ns).Get(ctx, setupRoleName, metav1.GetOptions{})
+			},
+			create: func(ctx context.Context, clientset kubernetes.Interface, opts metav1.CreateOptions) (runtime.Object, error) {
+				return clientset.RbacV1().Roles(ns).Create(ctx, o.role(), opts)
+			},
+			update: func(ctx context.Context, clientset kubernetes.Interface, obj runtime.Object, opts metav1.UpdateOptions) (runtime.Object, error) {
+				return clientset.RbacV1().Roles(ns).Update(ctx, obj.(*rbacv1.Role), opts)
+			},
+			merge: func(existing runtime.Object) runtime.Object {
+				r := existing.DeepCopyObject().(*rbacv1.Role)
+				r.Rules = o.role().Rules
+
+				return r
+			},
+		},
+		{
+			kind:    "rolebinding.rbac.authorization.k8s.io",
+			desired: o.roleBinding(),
+			get: func(ctx context.Context, clientset kubernetes.Interface) (runtime.Object, error) {
+				return clientset.RbacV1().RoleBindings(ns).Get(ctx, setupRoleName, metav1.GetOptions{})
+			},
+			create: func(ctx context.Context, clientset kubernetes.Interface, opts metav1.CreateOptions) (runtime.Object, error) {
+				return clientset.RbacV1().RoleBindings(ns).Create(ctx, o.roleBinding(), opts)
+			},
+			update: func(ctx context.Context, clientset kubernetes.Interface, obj runtime.Object, opts metav1.UpdateOptions) (runtime.Object, error) {
+				return clientset.RbacV1().RoleBindings(ns).Update(ctx, obj.(*rbacv1.RoleBinding), opts)
+			},
+			merge: func(existing runtime.Object) runtime.Object {
+				b := existing.DeepCopyObject().(*rbacv1.RoleBinding)
+				b.Subjects = o.roleBinding().Subjects
+				b.RoleRef = o.roleBinding().RoleRef
+
+				return b
+			},
+		},
+	}
+
+	if o.ca == nil {
+		return resources
+	}
+
+	return append(resources, setupResource{
+		kind:    "secret",
+		desired: o.trustSecret(),
+		get: func(ctx context.Context, clientset kubernetes.Interface) (runtime.Object, error) {
+			return clientset.CoreV1().Secrets(ns).Get(ctx, o.userSpecifiedVaultTrustSecret, metav1.GetOptions{})
+		},
+		create: func(ctx context.Context, clientset kubernetes.Interface, opts metav1.CreateOptions) (runtime.Object, error) {
+			return clientset.CoreV1().Secrets(ns).Create(ctx, o.trustSecret(), opts)
+		},
+		update: func(ctx context.Context, clientset kubernetes.Interface, obj runtime.Object, opts metav1.UpdateOptions) (runtime.Object, error) {
+			return clientset.CoreV1().Secrets(ns).Update(ctx, obj.(*v1.Secret), opts)
+		},
+		merge: func(existing runtime.Object) runtime.Object {
+			s := existing.DeepCopyObject().(*v1.Secret)
+			if s.Data == nil {
+				s.Data = map[string][]byte{}
+			}
+
+			s.Data[job.TruststoreKey] = o.ca
+
+			return s
+		},
+	})
+}
+
+// role returns the role that allows the synchronizer to manage secrets.
+func (o *SetupOptions) role() *rbacv1.Role {
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: setupRoleName, Namespace: o.currentNamespace},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"secrets"},
+				Verbs:     []string{"get", "list", "create", "update"},
+			},
+		},
+	}
+}
+
+// roleBinding returns the role binding of the role to the service account
+// of the sync job.
+func (o *SetupOptions) roleBinding() *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: setupRoleName, Namespace: o.currentNamespace},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      job.ServiceAccountName,
+				Namespace: o.currentNamespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     setupRoleName,
+		},
+	}
+}
+
+// trustSecret returns the secret with the CA certificates.
+func (o *SetupOptions) trustSecret() *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: o.userSpecifiedVaultTrustSecret, Namespace: o.currentNamespace},
+		Data: map[string][]byte{
+			job.TruststoreKey: o.ca,
+		},
+	}
+}
+
+// apply creates the object of r or updates it, if it differs.
+func (o *SetupOptions) apply(ctx context.Context, clientset kubernetes.Interface, r setupResource) error {
+	name := metaName(r.desired)
+	dryRun := []string{}
+
+	if o.userSpecifiedDryRun == dryRunServer {
+		dryRun = []string{metav1.DryRunAll}
+	}
+
+	existing, err := r.get(ctx, clientset)
+
+	switch {
+	case apierrors.IsNotFound(err) && r.create != nil:
+		created, err := r.create(ctx, clientset, metav1.CreateOptions{DryRun: dryRun})
+		if err != nil {
+			return fmt.Errorf("could not create %s %s: %s", r.kind, name, err)
+		}
+
+		return o.print(created, o.operation(actionCreated))
+	case err != nil:
+		return fmt.Errorf("could not get %s %s: %s", r.kind, name, err)
+	}
+
+	merged := r.merge(existing)
+
+	diff, err := objectDiff(r.kind+"/"+name, existing, merged)
+	if err != nil {
+		return err
+	}
+
+	if diff == "" {
+		return o.print(existing, o.operation(actionUnchanged))
+	}
+
+	if o.printer == nil {
+		fmt.Fprint(o.Out, diff)
+	}
+
+	updated, err := r.update(ctx, clientset, merged, metav1.UpdateOptions{DryRun: dryRun})
+	if err != nil {
+		return fmt.Errorf("could not update %s %s: %s", r.kind, name, err)
+	}
+
+	return o.print(updated, o.operation(actionConfigured))
+}
+
+// operation returns action with a hint for server dry runs.
+func (o *SetupOptions) operation(action string) string {
+	if o.userSpecifiedDryRun == dryRunServer {
+		return action + " (server dry run)"
+	}
+
+	return action
+}
+
+// print prints obj with the output format or its kind and name followed by
+// operation.
+func (o *SetupOptions) print(obj runtime.Object, operation string) error {
+	if o.printer != nil {
+		return o.printer.PrintObj(obj, o.Out)
+	}
+
+	p := &printers.NamePrinter{Operation: operation}
+
+	return printers.NewTypeSetter(scheme.Scheme).ToPrinter(p).PrintObj(obj, o.Out)
+}
+
+// objectDiff returns the difference of the objects as unified diff of their
+// YAML representation without the fields managed by the API server.
+func objectDiff(label string, existing, merged runtime.Object) (string, error) {
+	a, err := diffYAML(existing)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := diffYAML(merged)
+	if err != nil {
+		return "", err
+	}
+
+	if a == b {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "live " + label,
+		ToFile:   "setup " + label,
+		Context:  3,
+	})
+}
+
+func diffYAML(obj runtime.Object) (string, error) {
+	obj = obj.DeepCopyObject()
+
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+
+	m.SetManagedFields(nil)
+	m.SetResourceVersion("")
+	m.SetGeneration(0)
+
+	data, err := yaml.Marshal(obj)
+
+	return string(data), err
+}
+
+func metaName(obj runtime.Object) string {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+
+	return m.GetName()
+}
+
+// validateCA checks that data contains at least one PEM encoded certificate.
+func validateCA(data []byte) error {
+	found := false
+
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+
+		found = true
+	}
+
+	if !found {
+		return errors.New("no PEM encoded certificate found")
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestCmdSetup returns the setup command using clientset.
+func newTestCmdSetup(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewSetupOptions(streams)
+	o.clientset = clientset
+
+	return newCmdSetup(o)
+}
+
+// writeCA writes a self-signed CA certificate and returns its path.
+func writeCA(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vault-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return path
+}
+
+func TestSetup(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: testNamespace},
+	})
+	caFile := writeCA(t)
+	args := []string{
+		"--vault-role=role",
+		"--vault-addr=https://vault.example.com",
+		"--vault-secretspath=secret/team",
+		"--ca-file=" + caFile,
+	}
+
+	out, err := runCommand(t, newTestCmdSetup, clientset, args...)
+	require.NoError(t, err)
+	assert.Contains(t, out, "serviceaccount/vault-auth created")
+	assert.Contains(t, out, "role.rbac.authorization.k8s.io/vault-sync created")
+	assert.Contains(t, out, "rolebinding.rbac.authorization.k8s.io/vault-sync created")
+	assert.Contains(t, out, "secret/vault-tls created")
+	assert.Contains(t, out, "namespace/test configured")
+
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), testNamespace, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "role", ns.Annotations[vaultsync.AnnotationRole])
+	assert.Equal(t, "vault-tls", ns.Annotations[vaultsync.AnnotationTrustSecret])
+
+	s, err := clientset.CoreV1().Secrets(testNamespace).Get(context.Background(), "vault-tls", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	ca, err := os.ReadFile(caFile)
+	require.NoError(t, err)
+	assert.Equal(t, ca, s.Data[job.TruststoreKey])
+
+	// the synchronizer only creates and updates secrets, pruning runs with
+	// the user's credentials
+	role, err := clientset.RbacV1().Roles(testNamespace).Get(context.Background(), setupRoleName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, role.Rules, 1)
+	assert.Equal(t, []string{"get", "list", "create", "update"}, role.Rules[0].Verbs)
+
+	// running setup again changes nothing
+	out, err = runCommand(t, newTestCmdSetup, clientset, args...)
+	require.NoError(t, err)
+	assert.Equal(t, 5, strings.Count(out, "unchanged"), out)
+
+	// changed settings are shown as diff
+	out, err = runCommand(t, newTestCmdSetup, clientset, append(args, "--vault-role=other-role")...)
+	require.NoError(t, err)
+	assert.Contains(t, out, "-    sync.vault.postfinance.ch/role: role\n")
+	assert.Contains(t, out, "+    sync.vault.postfinance.ch/role: other-role\n")
+	assert.Contains(t, out, "namespace/test configured")
+	assert.Equal(t, 4, strings.Count(out, "unchanged"), out)
+}
+
+func TestSetupDryRun(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: testNamespace},
+	})
+
+	out, err := runCommand(t, newTestCmdSetup, clientset,
+		"--vault-role=role",
+		"--vault-addr=https://vault.example.com",
+		"--vault-secretspath=secret/team",
+		"--ca-file="+writeCA(t),
+		"--dry-run=client",
+		"-o", "yaml",
+	)
+	require.NoError(t, err)
+
+	for _, kind := range []string{"Namespace", "ServiceAccount", "Role", "RoleBinding", "Secret"} {
+		assert.Contains(t, out, "kind: "+kind+"\n")
+	}
+
+	assert.Equal(t, 4, strings.Count(out, "---\n"), out)
+	assert.Empty(t, clientset.Actions(), "a client dry run must not access the cluster")
+}
+
+func TestSetupValidate(t *testing.T) {
+	invalidCA := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(invalidCA, []byte("not a certificate"), 0o600))
+
+	var tt = []struct {
+		name        string
+		args        []string
+		expectedErr string
+	}{
+		{"missing role", []string{"--vault-addr=https://vault.example.com", "--vault-secretspath=secret/team"}, "are required"},
+		{"invalid CA", []string{"--vault-role=role", "--vault-addr=https://vault.example.com", "--vault-secretspath=secret/team", "--ca-file=" + invalidCA}, "no PEM encoded certificate found"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := runCommand(t, newTestCmdSetup, fake.NewSimpleClientset(), tc.args...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const statusNever = "<never>"
+
+var statusExample = `
+	# show the configuration and the last sync of the current namespace
+	%[1]s %[2]s status
+
+	# show the last sync of all namespaces with a secrets path annotation
+	%[1]s %[2]s status --all-namespaces
+
+	# show the last sync of all namespaces with label team=linux
+	%[1]s %[2]s status --namespace-selector team=linux
+`
+
+// StatusOptions provides information required to show the vault
+// synchronization status of a namespace.
+type StatusOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	userSpecifiedAllNamespaces     bool
+	userSpecifiedNamespaceSelector string
+
+	rawConfig api.Config
+
+	genericclioptions.IOStreams
+}
+
+// NewStatusOptions provides an instance of StatusOptions with default values
+func NewStatusOptions(streams genericclioptions.IOStreams) *StatusOptions {
+	o := &StatusOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdStatus provides a cobra command wrapping StatusOptions
+func NewCmdStatus(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdStatus(NewStatusOptions(streams))
+}
+
+// newCmdStatus provides a cobra command wrapping o.
+func newCmdStatus(o *StatusOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "status",
+		Short:        "Show the vault synchronization configuration and the state of the last sync job",
+		Example:      fmt.Sprintf(statusExample, "kubectl", Name),
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&o.userSpecifiedAllNamespaces, "all-namespaces", "A", false,
+		fmt.Sprintf("Show the last sync of all namespaces with a '%s' annotation.", vaultsync.AnnotationSecretsPath))
+	cmd.Flags().StringVar(&o.userSpecifiedNamespaceSelector, "namespace-selector", "",
+		"Show the last sync of all namespaces matching this label selector (e.g. team=linux).")
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for showing the status
+func (o *StatusOptions) Complete(cmd *cobra.Command, args []string) error {
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	return err
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *StatusOptions) Validate() error {
+	if o.userSpecifiedAllNamespaces || o.userSpecifiedNamespaceSelector != "" {
+		return nil
+	}
+
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run prints the namespace's vault annotations, its last sync and the state
+// of the last sync job, or with several namespaces a table of their last
+// syncs.
+func (o *StatusOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dfltTimeout)
+	defer cancel()
+
+	if o.userSpecifiedAllNamespaces || o.userSpecifiedNamespaceSelector != "" {
+		return o.printNamespaces(ctx, clientset)
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, o.currentNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get namespace %s: %s", o.currentNamespace, err)
+	}
+
+	w := printers.GetNewTabWriter(o.Out)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Namespace:\t%s\n", ns.Name)
+
+	for _, a := range []string{
+		vaultsync.AnnotationSecretsPath,
+		vaultsync.AnnotationSecretsPrefix,
+		vaultsync.AnnotationRole,
+		vaultsync.AnnotationAddr,
+		vaultsync.AnnotationMountPath,
+		vaultsync.AnnotationTrustSecret,
+		vaultsync.AnnotationSyncImage,
+		vaultsync.AnnotationAuthImage,
+	} {
+		v, ok := ns.GetAnnotations()[a]
+		if !ok {
+			v = "<not set>"
+		}
+
+		fmt.Fprintf(w, "%s:\t%s\n", a, v)
+	}
+
+	last := lastSyncOf(ns)
+	last.refresh(ctx, clientset, ns.Name, o.ErrOut)
+	fmt.Fprintf(w, "Last sync:\t%s\n", last.age())
+
+	if last.status != "" {
+		fmt.Fprintf(w, "Last sync status:\t%s\n", last.status)
+		fmt.Fprintf(w, "Last sync job:\t%s\n", valueOrNone(last.job))
+		fmt.Fprintf(w, "Last sync by:\t%s\n", valueOrNone(last.by))
+	}
+
+	j, err := latestSyncJob(ctx, clientset, o.currentNamespace)
+	if errors.Is(err, errNoSyncJob) {
+		fmt.Fprintf(w, "Last job:\t<none>\n")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Last job:\t%s\n", j.Name)
+	fmt.Fprintf(w, "Status:\t%s\n", vaultsync.JobStatus(j))
+	fmt.Fprintf(w, "Age:\t%s\n", duration.HumanDuration(metav1.Now().Sub(j.CreationTimestamp.Time)))
+
+	return nil
+}
+
+// printNamespaces prints the last sync of the selected namespaces. With
+// --all-namespaces only namespaces with a secrets path annotation are
+// selected, a namespace selector selects all matching namespaces.
+func (o *StatusOptions) printNamespaces(ctx context.Context, clientset kubernetes.Interface) error {
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: o.userSpecifiedNamespaceSelector})
+	if err != nil {
+		return fmt.Errorf("could not list namespaces: %s", err)
+	}
+
+	w := printers.GetNewTabWriter(o.Out)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "NAMESPACE\tLAST SYNC\tSTATUS\tJOB\tBY")
+
+	for i := range list.Items {
+		ns := &list.Items[i]
+
+		if _, ok := ns.GetAnnotations()[vaultsync.AnnotationSecretsPath]; !ok && o.userSpecifiedNamespaceSelector == "" {
+			continue
+		}
+
+		last := lastSyncOf(ns)
+		last.refresh(ctx, clientset, ns.Name, o.ErrOut)
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ns.Name, last.age(), valueOrNone(last.status), valueOrNone(last.job), valueOrNone(last.by))
+	}
+
+	return nil
+}
+
+// lastSync is the last sync of a namespace recorded in its annotations.
+type lastSync struct {
+	time   time.Time
+	status string
+	job    string
+	by     string
+}
+
+// lastSyncOf returns the last sync recorded in the annotations of ns.
+func lastSyncOf(ns *v1.Namespace) lastSync {
+	a := ns.GetAnnotations()
+	last := lastSync{
+		status: a[lastSyncStatusAnnotation],
+		job:    a[lastSyncJobAnnotation],
+		by:     a[lastSyncByAnnotation],
+	}
+
+	// an invalid time is shown as never synced
+	last.time, _ = time.Parse(time.RFC3339, a[lastSyncTimeAnnotation])
+
+	return last
+}
+
+// refresh replaces the status Running by the state of the job, since the
+// result of a sync is only recorded if the user waited for the job.
+func (l *lastSync) refresh(ctx context.Context, clientset kubernetes.Interface, namespace string, warnings io.Writer) {
+	if l.status != vaultsync.StatusRunning || l.job == "" {
+		return
+	}
+
+	j, err := clientset.BatchV1().Jobs(namespace).Get(ctx, l.job, metav1.GetOptions{})
+	if err != nil {
+		fmt.Fprintf(warnings, "Warning: could not get job %s in namespace %s: %s\n", l.job, namespace, err)
+		return
+	}
+
+	l.status = vaultsync.JobStatus(j)
+}
+
+// age returns the time since the last sync.
+func (l lastSync) age() string {
+	if l.time.IsZero() {
+		return statusNever
+	}
+
+	return duration.HumanDuration(time.Since(l.time)) + " ago"
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// newTestCmdStatus returns the status command using clientset.
+func newTestCmdStatus(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewStatusOptions(streams)
+	o.clientset = clientset
+
+	return newCmdStatus(o)
+}
+
+// syncedNamespace returns a namespace with a secrets path annotation and the
+// given last sync annotations.
+func syncedNamespace(name string, labels map[string]string, lastSync ...string) *v1.Namespace {
+	annotations := map[string]string{vaultsync.AnnotationSecretsPath: "secret/" + name}
+
+	if len(lastSync) == 4 {
+		annotations[lastSyncTimeAnnotation] = lastSync[0]
+		annotations[lastSyncStatusAnnotation] = lastSync[1]
+		annotations[lastSyncJobAnnotation] = lastSync[2]
+		annotations[lastSyncByAnnotation] = lastSync[3]
+	}
+
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+}
+
+func TestStatusNamespaces(t *testing.T) {
+	hourAgo := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	team := map[string]string{"team": "linux"}
+
+	running := syncJob("vault-sync-running", time.Minute, vaultsync.StatusSucceeded)
+	running.Namespace = "team-c"
+
+	clientset := newFakeClientset(
+		syncedNamespace("team-a", team, hourAgo, vaultsync.StatusSucceeded, "vault-sync-a", "alice@host"),
+		syncedNamespace("team-b", team, hourAgo, vaultsync.StatusFailed, "vault-sync-b", "bob@host"),
+		syncedNamespace("team-c", nil, hourAgo, vaultsync.StatusRunning, "vault-sync-running", "carol@host"),
+		syncedNamespace("team-d", team),
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unconfigured"}},
+		running,
+	)
+
+	out, err := runCommand(t, newTestCmdStatus, clientset, "--all-namespaces")
+	require.NoError(t, err)
+
+	assert.Regexp(t, `(?m)^NAMESPACE\s+LAST SYNC\s+STATUS\s+JOB\s+BY$`, out)
+	assert.Regexp(t, `(?m)^team-a\s+60m ago\s+Succeeded\s+vault-sync-a\s+alice@host$`, out)
+	assert.Regexp(t, `(?m)^team-b\s+60m ago\s+Failed\s+vault-sync-b\s+bob@host$`, out)
+	assert.Regexp(t, `(?m)^team-c\s+60m ago\s+Succeeded\s+vault-sync-running\s+carol@host$`, out, "the state of a running sync is taken from the job")
+	assert.Regexp(t, `(?m)^team-d\s+<never>\s+<none>\s+<none>\s+<none>$`, out)
+	assert.NotContains(t, out, "unconfigured")
+	assert.Contains(t, out, testNamespace)
+
+	out, err = runCommand(t, newTestCmdStatus, clientset, "--namespace-selector", "team=linux")
+	require.NoError(t, err)
+	assert.Len(t, regexp.MustCompile(`(?m)^team-`).FindAllString(out, -1), 3)
+	assert.NotContains(t, out, "team-c")
+}
+
+func TestStatus(t *testing.T) {
+	ns := syncedNamespace(testNamespace, nil, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), vaultsync.StatusSucceeded, "vault-sync-a", "alice@host")
+	clientset := newFakeClientset()
+	require.NoError(t, clientset.Tracker().Update(v1.SchemeGroupVersion.WithResource("namespaces"), ns, ""))
+
+	out, err := runCommand(t, newTestCmdStatus, clientset)
+	require.NoError(t, err)
+	assert.Regexp(t, `(?m)^Last sync:\s+60m ago$`, out)
+	assert.Regexp(t, `(?m)^Last sync status:\s+Succeeded$`, out)
+	assert.Regexp(t, `(?m)^Last sync job:\s+vault-sync-a$`, out)
+	assert.Regexp(t, `(?m)^Last sync by:\s+alice@host$`, out)
+	assert.Regexp(t, `(?m)^Last job:\s+<none>$`, out)
+}
@@ -0,0 +1,470 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+var (
+	namespaceExample = `
+	# synchronize all vault secrets (only works when with configured namespace annotations)
+	%[1]s %[2]s
+
+	# synchronize all vault secrets and wait for the job to finish:
+	%[1]s %[2]s --wait --timeout=30s
+
+	# synchronize all vault secrets and stream the synchronizer logs until the job has finished:
+	%[1]s %[2]s --follow --timeout=2m
+
+	# synchronize a vault secret 'confidential' (only works when secretspath namespace annotation is defined)
+	%[1]s %[2]s confidential
+
+	# view batch job as yaml (this creates no batch job)
+	%[1]s %[2]s --yaml
+
+	# validate the batch job and its pod against the API server and its admission webhooks (this creates no batch job)
+	%[1]s %[2]s --dry-run=server
+
+	# synchronize all vault secrets, wait for the job to finish and print the created job and the result as json:
+	%[1]s %[2]s --wait -o json
+
+	# synchronize all namespaces with a secrets path annotation and wait for the jobs to finish:
+	%[1]s %[2]s --all-namespaces --wait
+
+	# synchronize all namespaces with label team=linux, at most 10 at the same time:
+	%[1]s %[2]s --namespace-selector team=linux --concurrency 10
+
+`
+	longDesc = `
+Synchronize vault secrets into kubernetes secrets.
+
+This plugin creates a batch job that starts a vault-kubernetes-synchronizer container.
+For more details visit https://github.com/postfinance/vault-kubernetes.
+
+You can view the created job with: kubectl get jobs -l job=vault-sync
+
+If you run the plugin without option, the job synchronizes all keys from vault below configured secrets path. The
+configured secrets path is taken from %[1]s namespace annotation or from command line option.
+
+Most command line options can be set with namespace annotations. For example:
+	* %[2]s: configures the vault role to use for authentication
+	* %[3]s: configures the mount path where the Kubernetes auth method is enabled
+
+`
+)
+
+// SyncOptions provides information required to synchronize
+// vault keys as kubernetes secrets.
+type SyncOptions struct {
+	configFlags      *genericclioptions.ConfigFlags
+	clientset        clientsetFactory
+	currentNamespace string
+
+	jobOptions
+
+	userSpecifiedYAML    bool
+	userSpecifiedDryRun  string
+	userSpecifiedWait    bool
+	userSpecifiedFollow  bool
+	userSpecifiedTimeout time.Duration
+
+	userSpecifiedWaitForRunning bool
+	userSpecifiedReplace        bool
+	identity                    string
+
+	userSpecifiedPrune bool
+	userSpecifiedYes   bool
+
+	userSpecifiedRecord bool
+
+	printFlags   *genericclioptions.PrintFlags
+	printer      printers.ResourcePrinter
+	outputFormat string
+
+	userSpecifiedAllNamespaces     bool
+	userSpecifiedNamespaceSelector string
+	userSpecifiedConcurrency       int
+
+	rawConfig api.Config
+	args      []string
+
+	genericclioptions.IOStreams
+}
+
+// NewSyncOptions provides an instance of NamespaceOptions with default values
+func NewSyncOptions(streams genericclioptions.IOStreams) *SyncOptions {
+	o := &SyncOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		printFlags:  genericclioptions.NewPrintFlags("created").WithTypeSetter(scheme.Scheme),
+
+		IOStreams: streams,
+	}
+
+	o.clientset = func() (kubernetes.Interface, error) {
+		return newClientset(o.configFlags, o.ErrOut)
+	}
+
+	return o
+}
+
+// NewCmdSync provides a cobra command wrapping SyncOptions
+func NewCmdSync(streams genericclioptions.IOStreams) *cobra.Command {
+	return newCmdSync(NewSyncOptions(streams))
+}
+
+// newCmdSync provides a cobra command wrapping o.
+func newCmdSync(o *SyncOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "sync [secret]",
+		Short:             "Synchronize vault secrets into kubernetes secrets",
+		Long:              fmt.Sprintf(longDesc, vaultsync.AnnotationSecretsPath, vaultsync.AnnotationRole, vaultsync.AnnotationMountPath),
+		Example:           fmt.Sprintf(namespaceExample, "kubectl", Name),
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: o.completeSecrets,
+		SilenceUsage:      true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			return o.Run()
+		},
+	}
+
+	o.jobOptions.addFlags(cmd.Flags())
+	cmd.Flags().BoolVar(&o.userSpecifiedYAML, "yaml", false,
+		"Print job yaml to stdout without creating the job (same as --dry-run=client -o yaml).")
+	cmd.Flags().StringVar(&o.userSpecifiedDryRun, "dry-run", dryRunNone,
+		`Must be "none", "server", or "client". If client strategy, only print the job that would be created, without `+
+			`sending it. If server strategy, submit the job and its pod to the API server without persisting them and `+
+			`without deleting finished jobs.`)
+	cmd.Flags().BoolVar(&o.userSpecifiedWait, "wait", false,
+		"Wait for job to finish or fail.")
+	cmd.Flags().BoolVarP(&o.userSpecifiedFollow, "follow", "f", false,
+		"Stream the logs of the job's containers while waiting for the job to finish or fail (implies --wait).")
+	cmd.Flags().DurationVar(&o.userSpecifiedTimeout, "timeout", dfltTimeout,
+		"The length of time to wait before giving up (in combination with --wait or --follow flag).")
+	cmd.Flags().BoolVar(&o.userSpecifiedWaitForRunning, "wait-for-running", false,
+		"If a sync job is already running, wait for it to finish before the sync job is created (within --timeout).")
+	cmd.Flags().BoolVar(&o.userSpecifiedReplace, "replace", false,
+		"If a sync job is already running, delete it before the sync job is created.")
+	cmd.Flags().BoolVar(&o.userSpecifiedPrune, "prune", false,
+		fmt.Sprintf("After a successful sync of the full secrets path, delete the prefixed secrets the job did not synchronize "+
+			"(requires --wait or --follow). Secrets with the label %s=false are kept.", pruneLabel))
+	cmd.Flags().BoolVarP(&o.userSpecifiedYes, "yes", "y", false,
+		"Prune the secrets without confirmation (in combination with --prune).")
+	cmd.Flags().BoolVar(&o.userSpecifiedRecord, "record", true,
+		fmt.Sprintf("Record the start and the result of the sync as events on the namespace and in its annotations '%s', '%s', '%s' and '%s'. "+
+			"Recording is skipped without permission to create events or to patch the namespace.",
+			lastSyncTimeAnnotation, lastSyncStatusAnnotation, lastSyncJobAnnotation, lastSyncByAnnotation))
+	cmd.Flags().BoolVarP(&o.userSpecifiedAllNamespaces, "all-namespaces", "A", false,
+		fmt.Sprintf("Synchronize all namespaces with a '%s' annotation.", vaultsync.AnnotationSecretsPath))
+	cmd.Flags().StringVar(&o.userSpecifiedNamespaceSelector, "namespace-selector", "",
+		"Synchronize all namespaces matching this label selector (e.g. team=linux).")
+	cmd.Flags().IntVar(&o.userSpecifiedConcurrency, "concurrency", dfltConcurrency,
+		"Maximum number of namespaces synchronized at the same time (in combination with --all-namespaces or --namespace-selector).")
+	o.printFlags.AddFlags(cmd)
+	cmd.Flags().Lookup("output").Usage = fmt.Sprintf("Output format. One of: (%s).",
+		strings.Join(append([]string{outputWide}, o.printFlags.AllowedFormats()...), ", "))
+	o.configFlags.AddFlags(cmd.Flags())
+	registerNamespaceCompletion(cmd, o.clientset)
+
+	return cmd
+}
+
+// Complete sets all information required for updating the current context
+func (o *SyncOptions) Complete(cmd *cobra.Command, args []string) error {
+	o.args = args
+	o.identity = vaultsync.Identity()
+
+	if o.userSpecifiedFollow {
+		o.userSpecifiedWait = true
+	}
+
+	if o.userSpecifiedYAML {
+		if cmd.Flags().Changed("output") || cmd.Flags().Changed("dry-run") {
+			return errors.New("--yaml can not be used with --output or --dry-run")
+		}
+
+		o.userSpecifiedDryRun = dryRunClient
+		*o.printFlags.OutputFormat = "yaml"
+	}
+
+	switch o.userSpecifiedDryRun {
+	case dryRunNone:
+	case dryRunClient:
+		o.printFlags.NamePrintFlags.Operation = "created (dry run)"
+	case dryRunServer:
+		o.printFlags.NamePrintFlags.Operation = "created (server dry run)"
+	default:
+		return fmt.Errorf(`invalid dry-run value (%s). Must be "none", "server", or "client"`, o.userSpecifiedDryRun)
+	}
+
+	// without creating a job, there is no result to print besides the job itself
+	if o.userSpecifiedDryRun != dryRunNone && (*o.printFlags.OutputFormat == "" || *o.printFlags.OutputFormat == outputWide) {
+		*o.printFlags.OutputFormat = outputName
+	}
+
+	var err error
+
+	o.outputFormat = *o.printFlags.OutputFormat
+	if !o.humanOutput() {
+		o.printer, err = o.printFlags.ToPrinter()
+		if err != nil {
+			return err
+		}
+	}
+
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadProfile(o.configFlags, &o.rawConfig); err != nil {
+		return err
+	}
+
+	return o.loadPatchFile()
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *SyncOptions) Validate() error {
+	if len(o.args) > 1 {
+		return errors.New("only one or none argument is allowed")
+	}
+
+	if o.userSpecifiedDryRun != dryRunNone && o.userSpecifiedWait {
+		return errors.New("--dry-run can not be used with --wait or --follow")
+	}
+
+	if o.userSpecifiedWaitForRunning && o.userSpecifiedReplace {
+		return errors.New("--wait-for-running can not be used with --replace")
+	}
+
+	if err := o.validatePrune(); err != nil {
+		return err
+	}
+
+	if o.userSpecifiedAllNamespaces || o.userSpecifiedNamespaceSelector != "" {
+		return o.validateNamespaces()
+	}
+
+	var err error
+	o.currentNamespace, err = resolveNamespace(o.configFlags, &o.rawConfig)
+
+	return err
+}
+
+// Run creates a kubernetes batch job that starts a sync container.
+func (o *SyncOptions) Run() error {
+	clientset, err := o.clientset()
+	if err != nil {
+		return err
+	}
+
+	if err := o.loadClusterDefaults(clientset, o.ErrOut); err != nil {
+		return err
+	}
+
+	if o.userSpecifiedAllNamespaces || o.userSpecifiedNamespaceSelector != "" {
+		return o.runNamespaces(clientset)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.userSpecifiedTimeout)
+	defer cancel()
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, o.currentNamespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not create namespace api client: %s", err)
+	}
+
+	r := newSyncResult(ns.Name)
+
+	batchJob, secretPath, keep, err := o.newJob(ctx, clientset, ns)
+	if err != nil {
+		// a namespace that is not configured for vault sync is not recorded
+		if o.userSpecifiedDryRun == dryRunNone && !errors.Is(err, vaultsync.ErrNotConfigured) {
+			o.recordFailure(clientset, ns, r, err)
+		}
+
+		return err
+	}
+
+	if w := podSecurityWarning(ns, batchJob); w != "" {
+		fmt.Fprintf(o.ErrOut, "Warning: %s\n", w)
+	}
+
+	switch o.userSpecifiedDryRun {
+	case dryRunClient:
+		return o.printer.PrintObj(batchJob, o.Out)
+	case dryRunServer:
+		ctx, cancel = context.WithTimeout(context.Background(), o.userSpecifiedTimeout)
+		defer cancel()
+
+		created, err := serverDryRun(ctx, clientset, ns.Name, batchJob)
+		if err != nil {
+			return err
+		}
+
+		return o.printer.PrintObj(created, o.Out)
+	}
+
+	o.infof("creating sync batch job to synchronize '%s' vault key\n", secretPath)
+
+	ctx, cancel = context.WithTimeout(context.Background(), o.userSpecifiedTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	r.SecretPath = secretPath
+
+	created, err := o.createJob(ctx, clientset, ns.Name, batchJob, keep)
+	if err != nil {
+		o.recordFailure(clientset, ns, r, err)
+		return err
+	}
+
+	if err := o.printJob(created); err != nil {
+		return err
+	}
+
+	r.JobName = created.Name
+	r.Status = syncCreated
+
+	o.recordSync(clientset, ns, r)
+
+	if !o.userSpecifiedWait {
+		if o.outputFormat == outputWide {
+			o.printResultTable([]*SyncResult{r})
+		}
+
+		return nil
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), o.userSpecifiedTimeout)
+	defer cancel()
+
+	var waitErr error
+
+	// a pod that can not be started ends the sync with the same result as
+	// a failed job
+	if o.userSpecifiedFollow {
+		waitErr = followJobLogs(ctx, clientset, ns.Name, created.Name, o.logWriter())
+		if errors.Is(waitErr, context.DeadlineExceeded) {
+			waitErr = fmt.Errorf("timeout %v exceeded", o.userSpecifiedTimeout)
+		}
+	}
+
+	if waitErr == nil {
+		waitErr = o.waitForJob(ctx, clientset, ns.Name, created.Name)
+	}
+
+	r.Duration = since(start)
+
+	if waitErr != nil {
+		r.fail(waitErr)
+	} else {
+		r.Status = vaultsync.StatusSucceeded
+		o.infof("sync batch job %s succeeded after %s\n", r.JobName, r.Duration)
+	}
+
+	if waitErr == nil && o.userSpecifiedPrune {
+		r.Pruned, waitErr = o.prune(clientset, ns.Name, created.Name, o.userSpecifiedYes, o.logWriter())
+		for _, name := range r.Pruned {
+			o.infof("secret/%s deleted\n", name)
+		}
+
+		if waitErr != nil {
+			r.fail(waitErr)
+		}
+	}
+
+	o.recordSync(clientset, ns, r)
+
+	if err := o.printResult(created, r); err != nil {
+		return err
+	}
+
+	return waitErr
+}
+
+// newJob resolves the options for namespace ns, builds the sync job and
+// applies the patches. It returns the job, the vault secret path it
+// synchronizes and the retention limits of the finished jobs.
+func (o *SyncOptions) newJob(ctx context.Context, clientset kubernetes.Interface, ns *v1.Namespace) (*batchv1.Job, string, vaultsync.Retention, error) {
+	b := &vaultsync.Builder{
+		Suffix:      vaultsync.NewSuffix(time.Now()),
+		Annotations: map[string]string{vaultsync.AnnotationCreatedBy: o.identity},
+	}
+
+	if len(o.args) > 0 {
+		b.Secret = o.args[0]
+	}
+
+	batchJob, c, err := o.jobOptions.newJob(ctx, clientset, ns, b)
+	if err != nil {
+		return nil, "", vaultsync.Retention{}, err
+	}
+
+	keep, err := c.Retention()
+	if err != nil {
+		return nil, "", vaultsync.Retention{}, err
+	}
+
+	return batchJob, b.SecretPath(c), keep, nil
+}
+
+// runner returns the runner of the sync jobs, that keeps the finished jobs
+// within keep.
+func (o *SyncOptions) runner(clientset kubernetes.Interface, keep vaultsync.Retention) *vaultsync.Runner {
+	return &vaultsync.Runner{
+		Clientset:      clientset,
+		Identity:       o.identity,
+		Retention:      keep,
+		Replace:        o.userSpecifiedReplace,
+		WaitForRunning: o.userSpecifiedWaitForRunning,
+		Logf:           o.infof,
+	}
+}
+
+// createJob creates batchJob in namespace while holding the lock of the
+// namespace. If another sync job is running, it is deleted with --replace
+// or waited for with --wait-for-running. Otherwise an error is returned.
+func (o *SyncOptions) createJob(ctx context.Context, clientset kubernetes.Interface, namespace string, batchJob *batchv1.Job, keep vaultsync.Retention) (*batchv1.Job, error) {
+	created, err := o.runner(clientset, keep).Create(ctx, namespace, batchJob)
+	if errors.Is(err, vaultsync.ErrRunning) {
+		return nil, fmt.Errorf("%w, use --wait-for-running to wait for it or --replace to delete it", err)
+	}
+
+	return created, err
+}
+
+// waitForJob waits until the sync job with the given name has succeeded or
+// failed. If the job failed, the returned error describes the failed
+// container.
+func (o *SyncOptions) waitForJob(ctx context.Context, clientset kubernetes.Interface, namespace, name string) error {
+	err := o.runner(clientset, vaultsync.Retention{}).Wait(ctx, namespace, name)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timeout %v exceeded", o.userSpecifiedTimeout)
+	}
+
+	return err
+}
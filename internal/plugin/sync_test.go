@@ -0,0 +1,606 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/postfinance/kubectl-vault_sync/pkg/vaultsync"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTestCmdSync returns the sync command using clientset.
+func newTestCmdSync(streams genericclioptions.IOStreams, clientset clientsetFactory) *cobra.Command {
+	o := NewSyncOptions(streams)
+	o.clientset = clientset
+
+	return newCmdSync(o)
+}
+
+// syncJob returns a sync job created age ago with the given status.
+func syncJob(name string, age time.Duration, status string) *batchv1.Job {
+	j := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         testNamespace,
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			Labels: map[string]string{
+				"job": job.Name,
+			},
+		},
+	}
+
+	switch status {
+	case vaultsync.StatusRunning:
+		j.Status.Active = 1
+	case vaultsync.StatusSucceeded:
+		j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+	case vaultsync.StatusFailed:
+		j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: v1.ConditionTrue}}
+	}
+
+	return j
+}
+
+func TestSyncYAML(t *testing.T) {
+	clientset := newFakeClientset()
+
+	out, err := runCommand(t, newTestCmdSync, clientset, "--yaml", "confidential")
+	require.NoError(t, err)
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(out), nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &batchv1.Job{}, obj)
+
+	j := obj.(*batchv1.Job)
+	env := map[string]string{}
+
+	for _, e := range j.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+
+	assert.Equal(t, "secret/annotation/confidential", env["VAULT_SECRETS"])
+	assert.Equal(t, "annotation-", env["SECRET_PREFIX"])
+	assert.Equal(t, "annotation-sync-image", j.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "annotation-auth-image", j.Spec.Template.Spec.InitContainers[0].Image)
+	assert.Equal(t, int32(vaultsync.DefaultBackoffLimit), *j.Spec.BackoffLimit)
+	assert.Equal(t, int32(3600), *j.Spec.TTLSecondsAfterFinished)
+
+	jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items, "--yaml must not create a job")
+}
+
+func TestSyncDeletesFinishedJobs(t *testing.T) {
+	other := syncJob("other", time.Hour, vaultsync.StatusSucceeded)
+	other.Labels = nil
+
+	var tt = []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			"defaults keep the newest failed job",
+			nil,
+			[]string{"vault-sync-failed-new", "other"},
+		},
+		{
+			"keep the newest successful and failed jobs",
+			[]string{"--keep-successful=1", "--keep-failed=2"},
+			[]string{"vault-sync-succeeded-new", "vault-sync-failed-new", "vault-sync-failed-old", "other"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset(
+				syncJob("vault-sync-succeeded-old", 3*time.Hour, vaultsync.StatusSucceeded),
+				syncJob("vault-sync-succeeded-new", 2*time.Hour, vaultsync.StatusSucceeded),
+				syncJob("vault-sync-failed-old", 2*time.Hour, vaultsync.StatusFailed),
+				syncJob("vault-sync-failed-new", time.Hour, vaultsync.StatusFailed),
+				other,
+			)
+
+			_, err := runCommand(t, newTestCmdSync, clientset, tc.args...)
+			require.NoError(t, err)
+
+			jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+
+			names := []string{}
+			for _, j := range jobs.Items {
+				if j.CreationTimestamp.IsZero() {
+					continue // the new sync job
+				}
+
+				names = append(names, j.Name)
+			}
+
+			assert.ElementsMatch(t, tc.expected, names)
+			assert.Len(t, jobs.Items, len(tc.expected)+1)
+		})
+	}
+}
+
+func TestSyncWait(t *testing.T) {
+	var tt = []struct {
+		name        string
+		args        []string
+		condition   batchv1.JobConditionType
+		pod         func(jobName string) *v1.Pod
+		expectedErr []string
+	}{
+		{
+			"succeeded",
+			[]string{"--wait", "--timeout=500ms"},
+			batchv1.JobComplete,
+			nil,
+			nil,
+		},
+		{
+			"failed",
+			[]string{"--wait", "--timeout=500ms"},
+			batchv1.JobFailed,
+			func(jobName string) *v1.Pod {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      jobName + "-abcde",
+						Namespace: testNamespace,
+						Labels: map[string]string{
+							"job-name": jobName,
+						},
+					},
+					Status: v1.PodStatus{
+						InitContainerStatuses: []v1.ContainerStatus{{
+							Name:  "vault-auth",
+							State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}},
+						}},
+						ContainerStatuses: []v1.ContainerStatus{{
+							Name: "vault-sync",
+							State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+								ExitCode: 1,
+								Reason:   "Error",
+								Message:  "permission denied",
+							}},
+						}},
+					},
+				}
+			},
+			[]string{"container vault-sync", "exit code 1", "permission denied", "fake logs"},
+		},
+		{
+			"follow image pull error",
+			[]string{"--follow", "--timeout=5s"},
+			batchv1.JobFailed,
+			func(jobName string) *v1.Pod {
+				return &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      jobName + "-abcde",
+						Namespace: testNamespace,
+						Labels: map[string]string{
+							"job-name": jobName,
+						},
+					},
+					Spec: v1.PodSpec{
+						InitContainers: []v1.Container{{Name: "vault-auth"}},
+						Containers:     []v1.Container{{Name: "vault-sync"}},
+					},
+					Status: v1.PodStatus{
+						Phase: v1.PodPending,
+						InitContainerStatuses: []v1.ContainerStatus{{
+							Name: "vault-auth",
+							State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{
+								Reason:  "ImagePullBackOff",
+								Message: "image not found",
+							}},
+						}},
+					},
+				}
+			},
+			[]string{"container vault-auth", "ImagePullBackOff", "image not found"},
+		},
+		{
+			"timeout",
+			[]string{"--wait", "--timeout=500ms"},
+			"",
+			nil,
+			[]string{"timeout 500ms exceeded"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset()
+			jobWatch := watch.NewFake()
+			clientset.PrependWatchReactor("jobs", k8stesting.DefaultWatchReactor(jobWatch, nil))
+
+			if tc.condition != "" {
+				go finishJob(t, clientset, jobWatch, tc.condition, tc.pod)
+			}
+
+			_, err := runCommand(t, newTestCmdSync, clientset, tc.args...)
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+
+			for _, s := range tc.expectedErr {
+				assert.Contains(t, err.Error(), s)
+			}
+
+			// the failure is recorded, even if the job's pod never started
+			events, err := clientset.CoreV1().Events(testNamespace).List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+
+			reasons := []string{}
+			for _, e := range events.Items {
+				reasons = append(reasons, e.Reason)
+			}
+
+			assert.ElementsMatch(t, []string{eventReasonStarted, eventReasonFailed}, reasons)
+
+			ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), testNamespace, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, vaultsync.StatusFailed, ns.Annotations[lastSyncStatusAnnotation])
+		})
+	}
+}
+
+// finishJob waits for the sync job to be created, creates the pod returned
+// by pod (if not nil) and sends a watch event that finishes the job with
+// condition.
+func finishJob(t *testing.T, clientset *fake.Clientset, jobWatch *watch.FakeWatcher, condition batchv1.JobConditionType, pod func(string) *v1.Pod) {
+	var j *batchv1.Job
+
+	for j == nil {
+		jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(jobs.Items) > 0 {
+			j = &jobs.Items[0]
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if pod != nil {
+		if _, err := clientset.CoreV1().Pods(testNamespace).Create(context.Background(), pod(j.Name), metav1.CreateOptions{}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	j.Status.Conditions = append(j.Status.Conditions, batchv1.JobCondition{
+		Type:   condition,
+		Status: v1.ConditionTrue,
+	})
+
+	if err := clientset.Tracker().Update(batchv1.SchemeGroupVersion.WithResource("jobs"), j, testNamespace); err != nil {
+		t.Error(err)
+		return
+	}
+
+	jobWatch.Modify(j)
+}
+
+func TestSyncJobNamesAreUnique(t *testing.T) {
+	clientset := newFakeClientset()
+
+	// the jobs finish immediately, so that the second sync is not refused
+	clientset.PrependReactor("create", "jobs", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		j := a.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+
+		return false, nil, nil
+	})
+
+	// both syncs are very likely to happen within the same second, the
+	// first job is kept by --keep-successful
+	for i := 0; i < 2; i++ {
+		_, err := runCommand(t, newTestCmdSync, clientset, "--keep-successful=1")
+		require.NoError(t, err)
+	}
+
+	jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, jobs.Items, 2)
+	assert.NotEqual(t, jobs.Items[0].Name, jobs.Items[1].Name)
+	assert.NotEqual(t, jobs.Items[0].Labels["jobSuffix"], jobs.Items[1].Labels["jobSuffix"])
+
+	for _, j := range jobs.Items {
+		assert.Equal(t, job.Name+"-"+j.Labels["jobSuffix"], j.Name)
+	}
+}
+
+func TestSyncVaultNamespace(t *testing.T) {
+	var tt = []struct {
+		name                  string
+		args                  []string
+		expectedAuthNamespace string
+		expectedSyncNamespace string
+	}{
+		{"none", nil, "", ""},
+		{"same namespace", []string{"--vault-namespace=team-a"}, "team-a", "team-a"},
+		{"auth in parent namespace", []string{"--vault-namespace=team-a/app", "--vault-auth-namespace=team-a"}, "team-a", "team-a/app"},
+		{"auth in root namespace", []string{"--vault-namespace=team-a", "--vault-auth-namespace=root"}, "", "team-a"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runCommand(t, newTestCmdSync, newFakeClientset(), append(tc.args, "--yaml")...)
+			require.NoError(t, err)
+
+			obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(out), nil, nil)
+			require.NoError(t, err)
+
+			spec := obj.(*batchv1.Job).Spec.Template.Spec
+			assert.Equal(t, tc.expectedAuthNamespace, envValue(spec.InitContainers[0].Env, "VAULT_NAMESPACE"))
+			assert.Equal(t, tc.expectedSyncNamespace, envValue(spec.Containers[0].Env, "VAULT_NAMESPACE"))
+		})
+	}
+}
+
+// envValue returns the value of the environment variable name in env.
+func envValue(env []v1.EnvVar, name string) string {
+	for _, e := range env {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+
+	return ""
+}
+
+func TestSyncAuthMethod(t *testing.T) {
+	var tt = []struct {
+		name          string
+		args          []string
+		expectedImage string
+		expectedEnv   map[string]string
+		expectedErr   string
+	}{
+		{
+			"kubernetes",
+			nil,
+			"annotation-auth-image",
+			map[string]string{"VAULT_ROLE": "annotation-role", "VAULT_AUTH_MOUNT_PATH": "annotation-mountpath"},
+			"",
+		},
+		{
+			"jwt",
+			[]string{"--vault-auth-method=jwt", "--vault-mountpath=jwt"},
+			"annotation-auth-image",
+			map[string]string{"VAULT_ROLE": "annotation-role", "VAULT_AUTH_MOUNT_PATH": "jwt", "SERVICE_ACCOUNT_TOKEN_PATH": "/var/run/secrets/vault/token"},
+			"",
+		},
+		{
+			"approle with explicit image",
+			[]string{"--vault-auth-method=approle", "--vault-approle-secret=approle", "--vault-auth-image=" + vaultsync.DefaultAuthImage},
+			vaultsync.DefaultAuthImage,
+			map[string]string{"VAULT_AUTH_MOUNT_PATH": "annotation-mountpath"},
+			"",
+		},
+		{
+			"invalid",
+			[]string{"--vault-auth-method=ldap"},
+			"",
+			nil,
+			`invalid auth method "ldap"`,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runCommand(t, newTestCmdSync, newFakeClientset(), append(tc.args, "--yaml")...)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(out), nil, nil)
+			require.NoError(t, err)
+
+			initContainer := obj.(*batchv1.Job).Spec.Template.Spec.InitContainers[0]
+			assert.Equal(t, tc.expectedImage, initContainer.Image)
+
+			for name, value := range tc.expectedEnv {
+				assert.Equal(t, value, envValue(initContainer.Env, name), name)
+			}
+		})
+	}
+}
+
+func TestSyncRecord(t *testing.T) {
+	var tt = []struct {
+		name            string
+		condition       batchv1.JobConditionType
+		args            []string
+		expectedReasons []string
+		expectedStatus  string
+	}{
+		{"succeeded", batchv1.JobComplete, []string{"--wait"}, []string{eventReasonStarted, eventReasonSucceeded}, vaultsync.StatusSucceeded},
+		{"failed", batchv1.JobFailed, []string{"--wait"}, []string{eventReasonStarted, eventReasonFailed}, vaultsync.StatusFailed},
+		{"without wait", "", nil, []string{eventReasonStarted}, vaultsync.StatusRunning},
+		{"disabled", "", []string{"--record=false"}, nil, ""},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset()
+			jobWatch := watch.NewFake()
+			clientset.PrependWatchReactor("jobs", k8stesting.DefaultWatchReactor(jobWatch, nil))
+
+			if tc.condition != "" {
+				go finishJob(t, clientset, jobWatch, tc.condition, nil)
+			}
+
+			_, err := runCommand(t, newTestCmdSync, clientset, append([]string{"--timeout=5s"}, tc.args...)...)
+			if tc.condition == batchv1.JobFailed {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			events, err := clientset.CoreV1().Events(testNamespace).List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+
+			reasons := []string{}
+
+			for _, e := range events.Items {
+				reasons = append(reasons, e.Reason)
+				assert.Equal(t, "Namespace", e.InvolvedObject.Kind)
+				assert.Contains(t, e.Message, "secret/annotation/")
+
+				if e.Reason == eventReasonFailed {
+					assert.Equal(t, v1.EventTypeWarning, e.Type)
+				}
+			}
+
+			assert.ElementsMatch(t, tc.expectedReasons, reasons)
+
+			ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), testNamespace, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, ns.Annotations[lastSyncStatusAnnotation])
+
+			if tc.expectedStatus == "" {
+				return
+			}
+
+			jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, jobs.Items, 1)
+			assert.Equal(t, jobs.Items[0].Name, ns.Annotations[lastSyncJobAnnotation])
+			assert.Equal(t, vaultsync.Identity(), ns.Annotations[lastSyncByAnnotation])
+
+			_, err = time.Parse(time.RFC3339, ns.Annotations[lastSyncTimeAnnotation])
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSyncRecordWithoutJob(t *testing.T) {
+	var tt = []struct {
+		name        string
+		args        []string
+		expectedErr error
+	}{
+		{"running", nil, vaultsync.ErrRunning},
+		{"invalid setting", []string{"--ttl=forever"}, nil},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset(syncJob("vault-sync-running", time.Minute, vaultsync.StatusRunning))
+
+			_, err := runCommand(t, newTestCmdSync, clientset, tc.args...)
+			require.Error(t, err)
+
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			}
+
+			events, err := clientset.CoreV1().Events(testNamespace).List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, events.Items, 1)
+			assert.Equal(t, eventReasonFailed, events.Items[0].Reason)
+			assert.Equal(t, v1.EventTypeWarning, events.Items[0].Type)
+			assert.Contains(t, events.Items[0].Message, "before the sync job was created")
+
+			ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), testNamespace, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, vaultsync.StatusFailed, ns.Annotations[lastSyncStatusAnnotation])
+			assert.NotContains(t, ns.Annotations, lastSyncJobAnnotation)
+		})
+	}
+}
+
+func TestSyncRecordNotConfigured(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}})
+
+	_, err := runCommand(t, newTestCmdSync, clientset)
+	require.ErrorIs(t, err, vaultsync.ErrNotConfigured)
+
+	events, err := clientset.CoreV1().Events(testNamespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, events.Items)
+
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), testNamespace, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, ns.Annotations)
+}
+
+func TestSyncRecordErrors(t *testing.T) {
+	var tt = []struct {
+		name            string
+		err             error
+		expectedWarning bool
+	}{
+		{"forbidden", apierrors.NewForbidden(v1.Resource("events"), "", errors.New("denied")), false},
+		{"other error", errors.New("connection refused"), true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := newFakeClientset()
+			reactor := func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tc.err
+			}
+			clientset.PrependReactor("create", "events", reactor)
+			clientset.PrependReactor("patch", "namespaces", reactor)
+
+			streams, _, _, errOut := genericclioptions.NewTestIOStreams()
+			o := NewSyncOptions(streams)
+			o.userSpecifiedRecord = true
+
+			r := newSyncResult(testNamespace)
+			r.Status = vaultsync.StatusRunning
+			o.recordSync(clientset, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}}, r)
+
+			if !tc.expectedWarning {
+				assert.Empty(t, errOut.String(), "recording without permission must be skipped silently")
+				return
+			}
+
+			assert.Contains(t, errOut.String(), "Warning: could not record event on namespace test")
+			assert.Contains(t, errOut.String(), "Warning: could not record sync status on namespace test")
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/info"
+	"github.com/spf13/cobra"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// NewCmdVersion provides a cobra command printing the plugin's version information.
+func NewCmdVersion(streams genericclioptions.IOStreams, v info.Version) *cobra.Command {
+	return &cobra.Command{
+		Use:          "version",
+		Short:        "Print the version information",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		Run: func(c *cobra.Command, args []string) {
+			fmt.Fprintln(streams.Out, v.String())
+		},
+	}
+}
@@ -20,7 +20,7 @@ func main() {
 	pflag.CommandLine = flags
 
 	v := info.New(plugin.Name, version, date, commit)
-	root := plugin.NewCmdSync(genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr})
+	root := plugin.NewCmdVaultSync(genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}, v)
 	root.SetVersionTemplate("{{ .Version }}\n")
 	root.Version = v.String()
 
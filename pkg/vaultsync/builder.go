@@ -0,0 +1,310 @@
+package vaultsync
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/yaml"
+)
+
+// suffixRandomLength is the number of random characters of a job suffix,
+// as used by the API server for generated names.
+const suffixRandomLength = 5
+
+// NewSuffix returns a unique suffix for a sync job created at t. The random
+// part prevents name clashes of jobs created within the same second.
+func NewSuffix(t time.Time) string {
+	return t.Format("20060102-150405") + "-" + utilrand.String(suffixRandomLength)
+}
+
+// Builder builds sync jobs.
+type Builder struct {
+	// Secret is the vault secret below the secrets path to synchronize. If
+	// it is empty, all secrets below the secrets path are synchronized.
+	Secret string
+	// Suffix is appended to the job name, see NewSuffix.
+	Suffix string
+	// Annotations are added to the job, e.g. AnnotationCreatedBy.
+	Annotations map[string]string
+	// Patches are applied to the built job in order.
+	Patches []Patch
+}
+
+// SecretPath returns the vault secret path the job synchronizes.
+func (b *Builder) SecretPath(c *Config) string {
+	if b.Secret != "" {
+		return path.Join(c.SecretsPath, b.Secret)
+	}
+
+	return strings.TrimRight(c.SecretsPath, "/") + "/"
+}
+
+// Build builds the sync job with the settings c. Empty settings are
+// treated as their default, see Config. The settings are usually resolved
+// for a namespace with a Resolver.
+func (b *Builder) Build(c *Config) (*batchv1.Job, error) {
+	d := c.withDefaults()
+	c = &d
+
+	if annotation := c.Missing(); annotation != "" {
+		return nil, fmt.Errorf("setting is %w: annotation %s not found", ErrNotConfigured, annotation)
+	}
+
+	options := []func(*batchv1.Job){}
+
+	if b.Suffix != "" {
+		options = append(options, job.WithSuffix(b.Suffix))
+	}
+
+	for key, value := range b.Annotations {
+		options = append(options, job.WithAnnotation(key, value))
+	}
+
+	auth, err := c.auth()
+	if err != nil {
+		return nil, err
+	}
+
+	options = append(options,
+		job.WithAuthenticatorImage(c.AuthenticatorImage()),
+		job.WithSynchronizerImage(c.SyncImage),
+		job.WithSecretPrefix(c.SecretsPrefix),
+		job.WithVaultAddr(c.Addr),
+		job.WithAuth(auth),
+		job.WithVaultSecrets(b.SecretPath(c)),
+		job.WithTruststore(c.TrustSecret),
+		job.WithVaultNamespace(c.VaultNamespace),
+	)
+
+	switch c.VaultAuthNamespace {
+	case "":
+	case RootNamespace:
+		options = append(options, job.WithVaultAuthNamespace(""))
+	default:
+		options = append(options, job.WithVaultAuthNamespace(c.VaultAuthNamespace))
+	}
+
+	scheduling, err := c.schedulingOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	options = append(options, scheduling...)
+
+	hardened, err := strconv.ParseBool(c.Hardened)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hardened value %q: must be true or false", c.Hardened)
+	}
+
+	if hardened {
+		options = append(options, job.WithRestrictedSecurityContext())
+	}
+
+	backoffLimit, err := strconv.ParseInt(c.BackoffLimit, 10, 32)
+	if err != nil || backoffLimit < 0 {
+		return nil, fmt.Errorf("invalid backoff limit %q: must be a number >= 0", c.BackoffLimit)
+	}
+
+	options = append(options, job.WithBackoffLimit(int32(backoffLimit)))
+
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil || ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl %q: must be a duration >= 0", c.TTL)
+	}
+
+	if ttl > 0 {
+		options = append(options, job.WithTTL(ttl))
+	}
+
+	batchJob := job.New(options...)
+
+	for _, p := range b.Patches {
+		batchJob, err = applyPatch(batchJob, p.Data)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply patch of %s: %s", p.Source, err)
+		}
+	}
+
+	return batchJob, nil
+}
+
+// AuthenticatorImage returns the image of the authenticator init
+// container. The approle auth method uses the vault CLI image, unless
+// another image has been set.
+func (c *Config) AuthenticatorImage() string {
+	switch {
+	case c.AuthImage != "":
+		return c.AuthImage
+	case c.AuthMethod == AuthAppRole:
+		return DefaultCLIImage
+	}
+
+	return DefaultAuthImage
+}
+
+// auth returns the vault authentication of the init container.
+func (c *Config) auth() (job.Auth, error) {
+	switch c.AuthMethod {
+	case AuthKubernetes:
+		return job.KubernetesAuth{
+			Role:      c.Role,
+			MountPath: c.MountPath,
+		}, nil
+	case AuthJWT:
+		return job.JWTAuth{
+			Role:      c.Role,
+			MountPath: c.MountPath,
+			Audience:  c.JWTAudience,
+		}, nil
+	case AuthAppRole:
+		return job.AppRoleAuth{
+			MountPath:  c.MountPath,
+			SecretName: c.AppRoleSecret,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid auth method %q: must be one of %s", c.AuthMethod, strings.Join(AuthMethods, ", "))
+}
+
+// schedulingOptions returns the job options for the resources and the
+// scheduling of the job's pod.
+func (c *Config) schedulingOptions() ([]func(*batchv1.Job), error) {
+	options := []func(*batchv1.Job){}
+
+	requests, err := parseResourceList(c.Requests)
+	if err != nil {
+		return nil, fmt.Errorf("invalid requests %q: %s", c.Requests, err)
+	}
+
+	limits, err := parseResourceList(c.Limits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid limits %q: %s", c.Limits, err)
+	}
+
+	if len(requests) > 0 || len(limits) > 0 {
+		options = append(options, job.WithResources(v1.ResourceRequirements{
+			Requests: requests,
+			Limits:   limits,
+		}))
+	}
+
+	if c.NodeSelector != "" {
+		selector, err := labels.ConvertSelectorToLabelsMap(c.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector %q: %s", c.NodeSelector, err)
+		}
+
+		options = append(options, job.WithNodeSelector(selector))
+	}
+
+	if c.Tolerations != "" {
+		tolerations, err := parseTolerations(c.Tolerations)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tolerations %q: %s", c.Tolerations, err)
+		}
+
+		options = append(options, job.WithTolerations(tolerations...))
+	}
+
+	if c.Affinity != "" {
+		affinity := &v1.Affinity{}
+		if err := yaml.UnmarshalStrict([]byte(c.Affinity), affinity); err != nil {
+			return nil, fmt.Errorf("invalid affinity: %s", err)
+		}
+
+		options = append(options, job.WithAffinity(affinity))
+	}
+
+	if c.PriorityClassName != "" {
+		options = append(options, job.WithPriorityClassName(c.PriorityClassName))
+	}
+
+	if c.ActiveDeadline != "" {
+		d, err := time.ParseDuration(c.ActiveDeadline)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid active deadline %q: must be a duration of at least 1s", c.ActiveDeadline)
+		}
+
+		options = append(options, job.WithActiveDeadline(d))
+	}
+
+	return options, nil
+}
+
+// parseResourceList parses resources like 'cpu=100m,memory=64Mi'.
+func parseResourceList(s string) (v1.ResourceList, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	list := v1.ResourceList{}
+
+	for _, r := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(r), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not of the form <resource>=<quantity>", r)
+		}
+
+		name, value := parts[0], parts[1]
+
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity of %s: %s", name, err)
+		}
+
+		list[v1.ResourceName(name)] = q
+	}
+
+	return list, nil
+}
+
+// parseTolerations parses tolerations like 'key[=value][:effect]'. Without
+// value the toleration matches all taints with the key.
+func parseTolerations(s string) ([]v1.Toleration, error) {
+	tolerations := []v1.Toleration{}
+
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+
+		toleration := v1.Toleration{
+			Operator: v1.TolerationOpExists,
+		}
+
+		if i := strings.LastIndex(t, ":"); i >= 0 {
+			toleration.Effect = v1.TaintEffect(t[i+1:])
+			t = t[:i]
+
+			switch toleration.Effect {
+			case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+			default:
+				return nil, fmt.Errorf("invalid effect %q: must be one of NoSchedule, PreferNoSchedule, NoExecute", toleration.Effect)
+			}
+		}
+
+		parts := strings.SplitN(t, "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("toleration %q has no key", t)
+		}
+
+		toleration.Key = parts[0]
+
+		if len(parts) == 2 {
+			toleration.Operator = v1.TolerationOpEqual
+			toleration.Value = parts[1]
+		}
+
+		tolerations = append(tolerations, toleration)
+	}
+
+	return tolerations, nil
+}
@@ -0,0 +1,195 @@
+package vaultsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+// testConfig returns a complete config.
+func testConfig() *Config {
+	c := DefaultConfig()
+	c.SecretsPath = "secret/team"
+	c.Role = "team"
+	c.Addr = "https://vault.example.com"
+
+	return &c
+}
+
+func TestBuild(t *testing.T) {
+	b := &Builder{
+		Suffix:      "20190412-101357-abcde",
+		Annotations: map[string]string{AnnotationCreatedBy: "alice@host"},
+		Patches:     []Patch{{Source: "test", Data: []byte("metadata:\n  labels:\n    team: a\n")}},
+	}
+
+	j, err := b.Build(testConfig())
+	require.NoError(t, err)
+
+	assert.Equal(t, JobName+"-20190412-101357-abcde", j.Name)
+	assert.Equal(t, "alice@host", j.Annotations[AnnotationCreatedBy])
+	assert.Equal(t, "a", j.Labels["team"])
+	assert.Equal(t, DefaultAuthImage, j.Spec.Template.Spec.InitContainers[0].Image)
+	assert.Equal(t, DefaultSyncImage, j.Spec.Template.Spec.Containers[0].Image)
+	assert.Contains(t, j.Spec.Template.Spec.Containers[0].Env, v1.EnvVar{Name: "VAULT_SECRETS", Value: "secret/team/"})
+	assert.Equal(t, "secret/team/", b.SecretPath(testConfig()))
+
+	b = &Builder{Secret: "confidential"}
+	assert.Equal(t, "secret/team/confidential", b.SecretPath(testConfig()))
+}
+
+func TestBuildWithoutDefaultConfig(t *testing.T) {
+	c := &Config{
+		SecretsPath: "secret/team",
+		Role:        "team",
+		Addr:        "https://vault.example.com",
+	}
+
+	j, err := (&Builder{}).Build(c)
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultAuthImage, j.Spec.Template.Spec.InitContainers[0].Image)
+	assert.Equal(t, DefaultSyncImage, j.Spec.Template.Spec.Containers[0].Image)
+	assert.Contains(t, j.Spec.Template.Spec.InitContainers[0].Env, v1.EnvVar{Name: "VAULT_AUTH_MOUNT_PATH", Value: DefaultMountPath})
+	require.NotNil(t, j.Spec.Template.Spec.Containers[0].SecurityContext, "hardened by default")
+	require.NotNil(t, j.Spec.TTLSecondsAfterFinished)
+	assert.Equal(t, int32(time.Hour.Seconds()), *j.Spec.TTLSecondsAfterFinished)
+	assert.Equal(t, int32(DefaultBackoffLimit), *j.Spec.BackoffLimit)
+	assert.Empty(t, c.Hardened, "the settings are not modified")
+
+	keep, err := c.Retention()
+	require.NoError(t, err)
+	assert.Equal(t, Retention{Successful: DefaultKeepSuccessful, Failed: DefaultKeepFailed}, keep)
+}
+
+func TestBuildInvalid(t *testing.T) {
+	var tt = []struct {
+		name        string
+		config      func(c *Config)
+		patch       string
+		expectedErr string
+	}{
+		{"missing setting", func(c *Config) { c.Addr = "" }, "", "annotation " + AnnotationAddr + " not found"},
+		{"auth method", func(c *Config) { c.AuthMethod = "token" }, "", "invalid auth method"},
+		{"hardened", func(c *Config) { c.Hardened = "yes" }, "", "invalid hardened value"},
+		{"backoff limit", func(c *Config) { c.BackoffLimit = "-1" }, "", "invalid backoff limit"},
+		{"ttl", func(c *Config) { c.TTL = "1" }, "", "invalid ttl"},
+		{"patch", func(c *Config) {}, `{"metadata": {"name": "other"}}`, "could not apply patch of test"},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			c := testConfig()
+			tc.config(c)
+
+			b := &Builder{}
+			if tc.patch != "" {
+				b.Patches = []Patch{{Source: "test", Data: []byte(tc.patch)}}
+			}
+
+			_, err := b.Build(c)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestAuthenticatorImage(t *testing.T) {
+	var tt = []struct {
+		name          string
+		method        string
+		image         string
+		expectedImage string
+	}{
+		{"kubernetes", AuthKubernetes, "", DefaultAuthImage},
+		{"approle with default image", AuthAppRole, "", DefaultCLIImage},
+		{"approle with configured image", AuthAppRole, DefaultAuthImage, DefaultAuthImage},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			c := Config{AuthMethod: tc.method, AuthImage: tc.image}
+			assert.Equal(t, tc.expectedImage, c.AuthenticatorImage())
+		})
+	}
+}
+
+func TestBuildAppRoleHardened(t *testing.T) {
+	c := testConfig()
+	c.AuthMethod = AuthAppRole
+	c.AppRoleSecret = "approle"
+
+	j, err := (&Builder{}).Build(c)
+	require.NoError(t, err)
+
+	// the vault CLI image runs as root by default
+	auth := j.Spec.Template.Spec.InitContainers[0]
+	assert.Equal(t, DefaultCLIImage, auth.Image)
+	require.NotNil(t, auth.SecurityContext)
+	assert.True(t, *auth.SecurityContext.RunAsNonRoot)
+	require.NotNil(t, auth.SecurityContext.RunAsUser)
+	assert.NotZero(t, *auth.SecurityContext.RunAsUser)
+	assert.True(t, *auth.SecurityContext.ReadOnlyRootFilesystem)
+}
+
+func TestParseTolerations(t *testing.T) {
+	tolerations, err := parseTolerations("dedicated=infra:NoSchedule, gpu, spot:NoExecute")
+	require.NoError(t, err)
+
+	assert.Equal(t, []v1.Toleration{
+		{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "infra", Effect: v1.TaintEffectNoSchedule},
+		{Key: "gpu", Operator: v1.TolerationOpExists},
+		{Key: "spot", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute},
+	}, tolerations)
+
+	_, err = parseTolerations("dedicated=infra:Never")
+	require.Error(t, err)
+
+	_, err = parseTolerations(":NoSchedule")
+	require.Error(t, err)
+}
+
+func TestSchedulingOptions(t *testing.T) {
+	var tt = []struct {
+		name        string
+		config      Config
+		expectedErr bool
+	}{
+		{"none", Config{}, false},
+		{"valid", Config{
+			Requests:          "cpu=100m,memory=64Mi",
+			Limits:            "memory=128Mi",
+			NodeSelector:      "pool=infra",
+			Tolerations:       "dedicated=infra:NoSchedule",
+			Affinity:          `{"nodeAffinity":{"preferredDuringSchedulingIgnoredDuringExecution":[{"weight":1,"preference":{"matchExpressions":[{"key":"pool","operator":"In","values":["infra"]}]}}]}}`,
+			PriorityClassName: "infra-critical",
+			ActiveDeadline:    "10m",
+		}, false},
+		{"invalid requests", Config{Requests: "cpu"}, true},
+		{"invalid quantity", Config{Limits: "memory=lots"}, true},
+		{"invalid node selector", Config{NodeSelector: "pool"}, true},
+		{"invalid affinity", Config{Affinity: "nodeAffinity: {unknown: true}"}, true},
+		{"invalid active deadline", Config{ActiveDeadline: "10"}, true},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.config.schedulingOptions()
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
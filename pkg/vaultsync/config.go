@@ -0,0 +1,205 @@
+package vaultsync
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Config holds the settings of a sync job. The values have the format of
+// the corresponding namespace annotations, the JSON keys are the names of
+// the settings. Empty settings that have a default, except for
+// SecretsPrefix, are treated as the value of DefaultConfig, so that a
+// Config does not have to start from DefaultConfig.
+type Config struct {
+	// SecretsPath is the path of the secrets in vault (required).
+	SecretsPath string `json:"secrets-path,omitempty"`
+	// SecretsPrefix is prepended to the names of the kubernetes secrets.
+	SecretsPrefix string `json:"secrets-prefix,omitempty"`
+	// Role is the vault role of the kubernetes and jwt auth methods.
+	Role string `json:"role,omitempty"`
+	// Addr is the URL of the vault server (required).
+	Addr string `json:"addr,omitempty"`
+	// MountPath is the path where the vault auth method is enabled (required).
+	MountPath string `json:"mount-path,omitempty"`
+	// SyncImage is the image of the synchronizer container.
+	SyncImage string `json:"sync-image,omitempty"`
+	// AuthImage is the image of the authenticator init container. If it is
+	// empty, DefaultAuthImage or for the approle auth method
+	// DefaultCLIImage is used.
+	AuthImage string `json:"auth-image,omitempty"`
+	// TrustSecret is the secret with the CA certificate of vault.
+	TrustSecret string `json:"trust-secret,omitempty"`
+
+	// VaultNamespace is the vault enterprise namespace.
+	VaultNamespace string `json:"vault-namespace,omitempty"`
+	// VaultAuthNamespace is the vault enterprise namespace of the auth
+	// method, if it differs from VaultNamespace. RootNamespace selects the
+	// root namespace.
+	VaultAuthNamespace string `json:"vault-auth-namespace,omitempty"`
+	// AuthMethod is one of AuthMethods.
+	AuthMethod string `json:"auth-method,omitempty"`
+	// JWTAudience is the audience of the token of the jwt auth method.
+	JWTAudience string `json:"jwt-audience,omitempty"`
+	// AppRoleSecret is the secret with the credentials of the approle auth
+	// method.
+	AppRoleSecret string `json:"approle-secret,omitempty"`
+
+	// Requests and Limits are resources like 'cpu=100m,memory=64Mi'.
+	Requests string `json:"requests,omitempty"`
+	Limits   string `json:"limits,omitempty"`
+	// NodeSelector are node labels like 'pool=infra'.
+	NodeSelector string `json:"node-selector,omitempty"`
+	// Tolerations are comma separated 'key[=value][:effect]'.
+	Tolerations string `json:"tolerations,omitempty"`
+	// Affinity is the affinity of the pod as JSON or YAML.
+	Affinity          string `json:"affinity,omitempty"`
+	PriorityClassName string `json:"priority-class-name,omitempty"`
+	// ActiveDeadline is a duration like '10m'.
+	ActiveDeadline string `json:"active-deadline,omitempty"`
+	// Hardened is true or false.
+	Hardened string `json:"hardened,omitempty"`
+	// KeepSuccessful and KeepFailed are the numbers of finished jobs kept
+	// when a sync job is created.
+	KeepSuccessful string `json:"keep-successful,omitempty"`
+	KeepFailed     string `json:"keep-failed,omitempty"`
+	// TTL is the duration after which kubernetes deletes a finished job, 0
+	// keeps finished jobs.
+	TTL          string `json:"ttl,omitempty"`
+	BackoffLimit string `json:"backoff-limit,omitempty"`
+	// PatchConfigMap is a config map with a patch for the job, see
+	// ConfigMapPatch.
+	PatchConfigMap string `json:"patch-configmap,omitempty"`
+}
+
+// DefaultConfig returns the settings used if they are not configured
+// otherwise.
+func DefaultConfig() Config {
+	return Config{
+		SecretsPrefix:  DefaultSecretsPrefix,
+		MountPath:      DefaultMountPath,
+		SyncImage:      DefaultSyncImage,
+		AuthMethod:     DefaultAuthMethod,
+		JWTAudience:    DefaultJWTAudience,
+		Hardened:       DefaultHardened,
+		KeepSuccessful: strconv.Itoa(DefaultKeepSuccessful),
+		KeepFailed:     strconv.Itoa(DefaultKeepFailed),
+		TTL:            DefaultTTL,
+		BackoffLimit:   strconv.Itoa(DefaultBackoffLimit),
+	}
+}
+
+// withDefaults returns a copy of c with the empty settings set to their
+// default. An empty SecretsPrefix is kept, it synchronizes the secrets
+// without prefix.
+func (c *Config) withDefaults() Config {
+	d := DefaultConfig()
+	result := *c
+	defaults := d.Settings()
+
+	for i, s := range result.Settings() {
+		if *s.Value == "" && s.Name != "secrets-prefix" {
+			*s.Value = *defaults[i].Value
+		}
+	}
+
+	return result
+}
+
+// Setting is a setting of a Config.
+type Setting struct {
+	// Name is the annotation name without prefix.
+	Name       string
+	Annotation string
+	Required   bool
+	// Value points into the Config.
+	Value *string
+}
+
+// Settings returns the settings of c.
+func (c *Config) Settings() []Setting {
+	return []Setting{
+		{"secrets-path", AnnotationSecretsPath, true, &c.SecretsPath},
+		{"role", AnnotationRole, false, &c.Role},
+		{"addr", AnnotationAddr, true, &c.Addr},
+		{"mount-path", AnnotationMountPath, true, &c.MountPath},
+		{"secrets-prefix", AnnotationSecretsPrefix, false, &c.SecretsPrefix},
+		{"sync-image", AnnotationSyncImage, false, &c.SyncImage},
+		{"auth-image", AnnotationAuthImage, false, &c.AuthImage},
+		{"trust-secret", AnnotationTrustSecret, false, &c.TrustSecret},
+		{"vault-namespace", AnnotationVaultNamespace, false, &c.VaultNamespace},
+		{"vault-auth-namespace", AnnotationVaultAuthNamespace, false, &c.VaultAuthNamespace},
+		{"auth-method", AnnotationAuthMethod, false, &c.AuthMethod},
+		{"jwt-audience", AnnotationJWTAudience, false, &c.JWTAudience},
+		{"approle-secret", AnnotationAppRoleSecret, false, &c.AppRoleSecret},
+		{"requests", AnnotationRequests, false, &c.Requests},
+		{"limits", AnnotationLimits, false, &c.Limits},
+		{"node-selector", AnnotationNodeSelector, false, &c.NodeSelector},
+		{"tolerations", AnnotationTolerations, false, &c.Tolerations},
+		{"affinity", AnnotationAffinity, false, &c.Affinity},
+		{"priority-class-name", AnnotationPriorityClassName, false, &c.PriorityClassName},
+		{"active-deadline", AnnotationActiveDeadline, false, &c.ActiveDeadline},
+		{"hardened", AnnotationHardened, false, &c.Hardened},
+		{"keep-successful", AnnotationKeepSuccessful, false, &c.KeepSuccessful},
+		{"keep-failed", AnnotationKeepFailed, false, &c.KeepFailed},
+		{"ttl", AnnotationTTL, false, &c.TTL},
+		{"backoff-limit", AnnotationBackoffLimit, false, &c.BackoffLimit},
+		{"patch-configmap", AnnotationPatchConfigMap, false, &c.PatchConfigMap},
+	}
+}
+
+// Values returns the settings of c that are not empty by name.
+func (c *Config) Values() map[string]string {
+	values := map[string]string{}
+
+	for _, s := range c.Settings() {
+		if *s.Value != "" {
+			values[s.Name] = *s.Value
+		}
+	}
+
+	return values
+}
+
+// Missing returns the annotation of the first required setting without a
+// value or an empty string. The settings required for the authentication
+// depend on the auth method.
+func (c *Config) Missing() string {
+	for _, s := range c.Settings() {
+		if s.Required && *s.Value == "" {
+			return s.Annotation
+		}
+	}
+
+	switch {
+	case c.AuthMethod == AuthAppRole && c.AppRoleSecret == "":
+		return AnnotationAppRoleSecret
+	case c.AuthMethod != AuthAppRole && c.Role == "":
+		return AnnotationRole
+	}
+
+	return ""
+}
+
+// Retention is the number of finished sync jobs that are kept.
+type Retention struct {
+	Successful int
+	Failed     int
+}
+
+// Retention returns the retention limits of c.
+func (c *Config) Retention() (Retention, error) {
+	d := c.withDefaults()
+	c = &d
+
+	successful, err := strconv.Atoi(c.KeepSuccessful)
+	if err != nil || successful < 0 {
+		return Retention{}, fmt.Errorf("invalid number of successful jobs to keep %q: must be a number >= 0", c.KeepSuccessful)
+	}
+
+	failed, err := strconv.Atoi(c.KeepFailed)
+	if err != nil || failed < 0 {
+		return Retention{}, fmt.Errorf("invalid number of failed jobs to keep %q: must be a number >= 0", c.KeepFailed)
+	}
+
+	return Retention{Successful: successful, Failed: failed}, nil
+}
@@ -0,0 +1,101 @@
+package vaultsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The states of a sync job returned by JobStatus.
+const (
+	StatusRunning   = "Running"
+	StatusSucceeded = "Succeeded"
+	StatusFailed    = "Failed"
+)
+
+// ListJobs returns the sync jobs in namespace including the jobs created
+// by a cron job, newest first.
+func ListJobs(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]batchv1.Job, error) {
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("job=%s", JobName)})
+	if err != nil {
+		return nil, fmt.Errorf("could not list batch jobs: %s", err)
+	}
+
+	items := jobs.Items
+	sort.Slice(items, func(i, j int) bool {
+		return items[j].CreationTimestamp.Before(&items[i].CreationTimestamp)
+	})
+
+	return items, nil
+}
+
+// createdByCronJob returns true if the sync job j was created by a cron job.
+func createdByCronJob(j *batchv1.Job) bool {
+	owner := metav1.GetControllerOf(j)
+	return owner != nil && owner.Kind == "CronJob"
+}
+
+// JobPods returns the pods of a job, newest first.
+func JobPods(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) ([]v1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("job-name=%s", jobName)})
+	if err != nil {
+		return nil, fmt.Errorf("could not list pods of job %s: %s", jobName, err)
+	}
+
+	items := pods.Items
+	sort.Slice(items, func(i, j int) bool {
+		return items[j].CreationTimestamp.Before(&items[i].CreationTimestamp)
+	})
+
+	return items, nil
+}
+
+// JobStatus returns a short human readable state of a sync job.
+func JobStatus(j *batchv1.Job) string {
+	for _, c := range j.Status.Conditions {
+		if c.Status != v1.ConditionTrue {
+			continue
+		}
+
+		switch c.Type {
+		case batchv1.JobComplete:
+			return StatusSucceeded
+		case batchv1.JobFailed:
+			return StatusFailed
+		}
+	}
+
+	return StatusRunning
+}
+
+// activeJob returns the newest sync job in namespace that has not finished
+// or nil if there is none. The jobs created by a cron job are included.
+func activeJob(ctx context.Context, clientset kubernetes.Interface, namespace string) (*batchv1.Job, error) {
+	jobs, err := ListJobs(ctx, clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range jobs {
+		// a job that has just been created has no active pod yet
+		if jobs[i].DeletionTimestamp == nil && JobStatus(&jobs[i]) == StatusRunning {
+			return &jobs[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// createdBy returns who created the sync job j.
+func createdBy(j *batchv1.Job) string {
+	if by := j.Annotations[AnnotationCreatedBy]; by != "" {
+		return by
+	}
+
+	return "<none>"
+}
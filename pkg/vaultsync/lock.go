@@ -0,0 +1,133 @@
+package vaultsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LeaseDuration is the time after which a lock that has not been released
+// is considered stale, e.g. if its holder has been killed. The lock is the
+// lease JobName in the namespace of the sync job.
+const LeaseDuration = 30 * time.Second
+
+// Identity returns the local user name and the host name, the default
+// identity recorded in the lock.
+func Identity() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return name + "@" + host
+}
+
+// acquireLock acquires the lease that guards the creation of sync jobs in
+// namespace. It returns an error wrapping ErrLocked, if the lease is held by
+// someone else. The returned function releases the lease.
+func acquireLock(ctx context.Context, clientset kubernetes.Interface, namespace, holder string) (func(), error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	now := metav1.NowMicro()
+	seconds := int32(LeaseDuration.Seconds())
+	spec := coordinationv1.LeaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+
+	lease, err := leases.Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: JobName,
+			Labels: map[string]string{
+				"job": JobName,
+			},
+		},
+		Spec: spec,
+	}, metav1.CreateOptions{})
+
+	if apierrors.IsAlreadyExists(err) {
+		lease, err = leases.Get(ctx, JobName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not get lease %s: %s", JobName, err)
+		}
+
+		if leaseHeld(lease, now.Time) {
+			return nil, fmt.Errorf("%w: lease %s in namespace %s is held by %s since %s", ErrLocked,
+				JobName, namespace, stringValue(lease.Spec.HolderIdentity), heldSince(lease).Format(time.RFC3339))
+		}
+
+		// the lease is stale, take it over
+		lease.Spec = spec
+
+		lease, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			return nil, fmt.Errorf("%w: lease %s in namespace %s has been acquired concurrently", ErrLocked, JobName, namespace)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire lease %s: %s", JobName, err)
+	}
+
+	release := func() {
+		// the lease is only deleted if it has not been taken over in the meantime
+		_ = leases.Delete(context.Background(), JobName, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{
+				UID:             &lease.UID,
+				ResourceVersion: &lease.ResourceVersion,
+			},
+		})
+	}
+
+	return release, nil
+}
+
+// leaseHeld returns true, if lease has been acquired or renewed within its
+// duration before now.
+func leaseHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return false
+	}
+
+	renewed := lease.Spec.RenewTime
+	if renewed == nil {
+		renewed = lease.Spec.AcquireTime
+	}
+
+	if renewed == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+
+	return renewed.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).After(now)
+}
+
+// heldSince returns the time lease has been acquired or, if another client
+// did not set it, renewed.
+func heldSince(lease *coordinationv1.Lease) *metav1.MicroTime {
+	if lease.Spec.AcquireTime != nil {
+		return lease.Spec.AcquireTime
+	}
+
+	return lease.Spec.RenewTime
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
@@ -0,0 +1,103 @@
+package vaultsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// PatchConfigMapKey is the key of the patch in the config map of the
+// setting patch-configmap.
+const PatchConfigMapKey = "patch"
+
+// Patch is a strategic merge patch or a JSON patch (RFC 6902) of a sync
+// job, both as JSON or YAML. A JSON patch is a list of operations.
+type Patch struct {
+	// Source describes the origin of the patch in errors, e.g. 'file
+	// patch.yaml'.
+	Source string
+	Data   []byte
+}
+
+// ConfigMapPatch returns the patch of the config map configured in c in
+// namespace. It returns nil if no config map is configured.
+func ConfigMapPatch(ctx context.Context, clientset kubernetes.Interface, namespace string, c *Config) (*Patch, error) {
+	name := c.PatchConfigMap
+	if name == "" {
+		return nil, nil
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get patch config map %s: %s", name, err)
+	}
+
+	data, ok := cm.Data[PatchConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("patch config map %s has no key %s", name, PatchConfigMapKey)
+	}
+
+	return &Patch{Source: "config map " + name, Data: []byte(data)}, nil
+}
+
+// applyPatch applies the patch data to batchJob. The patched job must
+// still be a valid sync job.
+func applyPatch(batchJob *batchv1.Job, data []byte) (*batchv1.Job, error) {
+	patch, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch: %s", err)
+	}
+
+	original, err := json.Marshal(batchJob)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+
+	if bytes.HasPrefix(bytes.TrimSpace(patch), []byte("[")) {
+		p, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON patch: %s", err)
+		}
+
+		patched, err = p.Apply(original)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		patched, err = strategicpatch.StrategicMergePatch(original, patch, batchv1.Job{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// unknown fields are most likely typos in the patch
+	d := json.NewDecoder(bytes.NewReader(patched))
+	d.DisallowUnknownFields()
+
+	result := &batchv1.Job{}
+	if err := d.Decode(result); err != nil {
+		return nil, fmt.Errorf("invalid patched job: %s", err)
+	}
+
+	if result.Name != batchJob.Name {
+		return nil, fmt.Errorf("invalid patched job: the name %s must not be changed", batchJob.Name)
+	}
+
+	if err := job.Validate(result); err != nil {
+		return nil, fmt.Errorf("invalid patched job: %s", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package vaultsync
+
+import (
+	"testing"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestApplyPatch(t *testing.T) {
+	var tt = []struct {
+		name        string
+		patch       string
+		check       func(t *testing.T, j *batchv1.Job)
+		expectedErr string
+	}{
+		{
+			"strategic merge patch",
+			`
+metadata:
+  labels:
+    cost-center: "4711"
+spec:
+  template:
+    metadata:
+      annotations:
+        sidecar.istio.io/inject: "false"
+    spec:
+      hostAliases:
+      - ip: 10.0.0.1
+        hostnames: [vault.example.com]
+      containers:
+      - name: vault-sync
+        env:
+        - name: HTTPS_PROXY
+          value: http://proxy:3128
+`,
+			func(t *testing.T, j *batchv1.Job) {
+				assert.Equal(t, "4711", j.Labels["cost-center"])
+				assert.Equal(t, JobName, j.Labels["job"])
+				assert.Equal(t, "false", j.Spec.Template.Annotations["sidecar.istio.io/inject"])
+				assert.Equal(t, "vault.example.com", j.Spec.Template.Spec.HostAliases[0].Hostnames[0])
+				require.Len(t, j.Spec.Template.Spec.Containers, 1)
+				assert.Equal(t, "sync-image", j.Spec.Template.Spec.Containers[0].Image)
+				assert.Contains(t, j.Spec.Template.Spec.Containers[0].Env, v1.EnvVar{Name: "HTTPS_PROXY", Value: "http://proxy:3128"})
+			},
+			"",
+		},
+		{
+			"JSON patch",
+			`[{"op": "add", "path": "/metadata/labels/cost-center", "value": "4711"}]`,
+			func(t *testing.T, j *batchv1.Job) {
+				assert.Equal(t, "4711", j.Labels["cost-center"])
+			},
+			"",
+		},
+		{
+			"JSON patch as YAML",
+			`
+- op: replace
+  path: /spec/template/spec/containers/0/image
+  value: other-image
+`,
+			func(t *testing.T, j *batchv1.Job) {
+				assert.Equal(t, "other-image", j.Spec.Template.Spec.Containers[0].Image)
+			},
+			"",
+		},
+		{
+			"removed container",
+			`[{"op": "remove", "path": "/spec/template/spec/containers/0"}]`,
+			nil,
+			"container vault-sync is missing",
+		},
+		{
+			"renamed job",
+			`{"metadata": {"name": "other"}}`,
+			nil,
+			"the name vault-sync must not be changed",
+		},
+		{
+			"unknown field",
+			`{"spec": {"template": {"spec": {"hostAlias": []}}}}`,
+			nil,
+			`unknown field "hostAlias"`,
+		},
+		{
+			"invalid JSON patch",
+			`[{"op": "remove", "path": "/spec/missing"}]`,
+			nil,
+			"missing",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			j := job.New(
+				job.WithAuthenticatorImage("auth-image"),
+				job.WithSynchronizerImage("sync-image"),
+			)
+
+			patched, err := applyPatch(j, []byte(tc.patch))
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			tc.check(t, patched)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package vaultsync
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// The sources of the resolved settings besides the ones of the Resolver.
+const (
+	SourceAnnotation = "annotation"
+	SourceDefault    = "default"
+)
+
+// Source provides settings by name, e.g. the keys of a config map.
+type Source struct {
+	// Name is recorded as the source of the settings it provides.
+	Name   string
+	Values map[string]string
+}
+
+// Resolver resolves the settings of a namespace from its annotations.
+type Resolver struct {
+	// Config holds the settings that are not provided otherwise, usually
+	// DefaultConfig().
+	Config Config
+	// Overrides provide settings that take precedence over the namespace
+	// annotations, e.g. command line flags. Empty values are used as well.
+	Overrides []Source
+	// Fallbacks provide the settings without namespace annotation, in
+	// order of precedence. Empty values are ignored.
+	Fallbacks []Source
+}
+
+// NewResolver returns a resolver with the default settings.
+func NewResolver(fallbacks ...Source) *Resolver {
+	return &Resolver{
+		Config:    DefaultConfig(),
+		Fallbacks: fallbacks,
+	}
+}
+
+// Resolve returns the settings of namespace ns and the name of the source
+// of each setting. The error wraps ErrNotConfigured if a required setting
+// is missing, the settings are resolved nevertheless.
+func (r *Resolver) Resolve(ns *v1.Namespace) (*Config, map[string]string, error) {
+	c := r.Config
+	sources := make(map[string]string)
+
+	for _, s := range c.Settings() {
+		sources[s.Name] = r.resolve(ns, s)
+	}
+
+	if annotation := c.Missing(); annotation != "" {
+		return &c, sources, fmt.Errorf("namespace %s is %w: annotation %s not found", ns.Name, ErrNotConfigured, annotation)
+	}
+
+	return &c, sources, nil
+}
+
+// resolve sets the value of s and returns its source.
+func (r *Resolver) resolve(ns *v1.Namespace, s Setting) string {
+	for _, o := range r.Overrides {
+		if value, ok := o.Values[s.Name]; ok {
+			*s.Value = value
+			return o.Name
+		}
+	}
+
+	if annotation, ok := ns.GetAnnotations()[s.Annotation]; ok {
+		*s.Value = annotation
+		return SourceAnnotation
+	}
+
+	for _, f := range r.Fallbacks {
+		if value := f.Values[s.Name]; value != "" {
+			*s.Value = value
+			return f.Name
+		}
+	}
+
+	return SourceDefault
+}
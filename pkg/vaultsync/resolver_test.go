@@ -0,0 +1,80 @@
+package vaultsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolve(t *testing.T) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "team",
+		Annotations: map[string]string{
+			AnnotationSecretsPath:   "secret/annotation",
+			AnnotationRole:          "annotation-role",
+			AnnotationSecretsPrefix: "annotation-",
+		},
+	}}
+
+	r := NewResolver(
+		Source{Name: "cluster", Values: map[string]string{"addr": "https://cluster.vault.io", "trust-secret": ""}},
+		Source{Name: "profile", Values: map[string]string{"addr": "https://profile.vault.io", "trust-secret": "profile-tls"}},
+	)
+	r.Overrides = []Source{{Name: "flag", Values: map[string]string{"secrets-prefix": ""}}}
+
+	c, sources, err := r.Resolve(ns)
+	require.NoError(t, err)
+
+	assert.Equal(t, "secret/annotation", c.SecretsPath)
+	assert.Equal(t, "", c.SecretsPrefix, "empty overrides take precedence")
+	assert.Equal(t, "https://cluster.vault.io", c.Addr)
+	assert.Equal(t, "profile-tls", c.TrustSecret, "empty fallbacks are ignored")
+	assert.Equal(t, DefaultMountPath, c.MountPath)
+
+	assert.Equal(t, map[string]string{
+		"secrets-path":   SourceAnnotation,
+		"secrets-prefix": "flag",
+		"addr":           "cluster",
+		"trust-secret":   "profile",
+		"mount-path":     SourceDefault,
+	}, map[string]string{
+		"secrets-path":   sources["secrets-path"],
+		"secrets-prefix": sources["secrets-prefix"],
+		"addr":           sources["addr"],
+		"trust-secret":   sources["trust-secret"],
+		"mount-path":     sources["mount-path"],
+	})
+	assert.Equal(t, DefaultConfig(), r.Config, "resolve must not modify the resolver")
+}
+
+func TestResolveNotConfigured(t *testing.T) {
+	var tt = []struct {
+		name               string
+		annotations        map[string]string
+		expectedAnnotation string
+	}{
+		{"secrets path", map[string]string{AnnotationRole: "role", AnnotationAddr: "https://vault.io"}, AnnotationSecretsPath},
+		{"role", map[string]string{AnnotationSecretsPath: "secret/team", AnnotationAddr: "https://vault.io"}, AnnotationRole},
+		{"approle secret", map[string]string{
+			AnnotationSecretsPath: "secret/team",
+			AnnotationAddr:        "https://vault.io",
+			AnnotationAuthMethod:  AuthAppRole,
+		}, AnnotationAppRoleSecret},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team", Annotations: tc.annotations}}
+
+			c, _, err := NewResolver().Resolve(ns)
+			require.ErrorIs(t, err, ErrNotConfigured)
+			assert.Contains(t, err.Error(), tc.expectedAnnotation)
+			assert.Equal(t, tc.annotations[AnnotationAddr], c.Addr, "the settings are resolved nevertheless")
+		})
+	}
+}
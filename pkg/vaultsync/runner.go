@@ -0,0 +1,215 @@
+package vaultsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+)
+
+// lockRetryInterval is the time between attempts to acquire a held lock.
+const lockRetryInterval = 2 * time.Second
+
+// Runner creates sync jobs, waits for them and deletes finished ones. All
+// operations stop when their context is done.
+type Runner struct {
+	Clientset kubernetes.Interface
+	// Identity is recorded as holder of the lock. Identity() is used if it
+	// is empty.
+	Identity string
+	// Retention limits the finished sync jobs that are kept when a sync job
+	// is created.
+	Retention Retention
+	// Replace deletes a running sync job before a sync job is created.
+	Replace bool
+	// WaitForRunning waits for a running sync job to finish and for a held
+	// lock to be released before a sync job is created.
+	WaitForRunning bool
+	// Logf receives progress messages, if it is set.
+	Logf func(format string, args ...interface{})
+}
+
+// Create creates batchJob in namespace while holding the lock of the
+// namespace. The finished sync jobs beyond the retention limits are deleted
+// first. If another sync job is running, it is deleted with Replace or
+// waited for with WaitForRunning. Otherwise an error wrapping ErrRunning is
+// returned.
+func (r *Runner) Create(ctx context.Context, namespace string, batchJob *batchv1.Job) (*batchv1.Job, error) {
+	for {
+		created, running, err := r.tryCreate(ctx, namespace, batchJob)
+
+		switch {
+		case errors.Is(err, ErrLocked) && r.WaitForRunning:
+			r.logf("%s, retrying in %s\n", err, lockRetryInterval)
+
+			select {
+			case <-time.After(lockRetryInterval):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("could not acquire the lock of namespace %s: %w", namespace, ctx.Err())
+			}
+
+			continue
+		case err != nil:
+			return nil, err
+		case running == nil:
+			return created, nil
+		}
+
+		r.logf("waiting for sync job %s started by %s to finish\n", running.Name, createdBy(running))
+
+		if err := watchJob(ctx, r.Clientset, namespace, running.Name); err != nil && ctx.Err() != nil {
+			return nil, fmt.Errorf("could not wait for sync job %s: %w", running.Name, ctx.Err())
+		}
+	}
+}
+
+// tryCreate creates batchJob in namespace while holding the lock of the
+// namespace. With WaitForRunning, it returns the running sync job instead
+// of creating batchJob.
+func (r *Runner) tryCreate(ctx context.Context, namespace string, batchJob *batchv1.Job) (*batchv1.Job, *batchv1.Job, error) {
+	identity := r.Identity
+	if identity == "" {
+		identity = Identity()
+	}
+
+	release, err := acquireLock(ctx, r.Clientset, namespace, identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	running, err := activeJob(ctx, r.Clientset, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if running != nil {
+		switch {
+		case r.Replace:
+			r.logf("deleting running sync job %s started by %s\n", running.Name, createdBy(running))
+
+			deletePolicy := metav1.DeletePropagationForeground
+			if err := r.Clientset.BatchV1().Jobs(namespace).Delete(ctx, running.Name, metav1.DeleteOptions{
+				PropagationPolicy: &deletePolicy,
+			}); err != nil {
+				return nil, nil, fmt.Errorf("could not delete batch job %s: %s", running.Name, err)
+			}
+		case r.WaitForRunning:
+			return nil, running, nil
+		default:
+			return nil, nil, fmt.Errorf("%w: job %s in namespace %s was started by %s %s ago", ErrRunning,
+				running.Name, running.Namespace, createdBy(running), duration.HumanDuration(time.Since(running.CreationTimestamp.Time)))
+		}
+	}
+
+	if _, err := r.Cleanup(ctx, namespace); err != nil {
+		return nil, nil, err
+	}
+
+	created, err := r.Clientset.BatchV1().Jobs(namespace).Create(ctx, batchJob, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create batch job %s: %s", batchJob.Name, err)
+	}
+
+	return created, nil, nil
+}
+
+// Wait waits until the sync job with the given name in namespace has
+// succeeded or failed. Closed watches are restarted. The job's pods are
+// watched as well, so that waiting stops early if a container can not be
+// started, e.g. because its image can not be pulled. If the job failed, the
+// returned error is a *ContainerError if the failed container is found.
+func (r *Runner) Wait(ctx context.Context, namespace, name string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	podErr := make(chan error, 1)
+
+	go func() {
+		podErr <- watchJobPods(ctx, r.Clientset, namespace, name)
+	}()
+
+	jobResult := make(chan error, 1)
+
+	go func() {
+		jobResult <- watchJob(ctx, r.Clientset, namespace, name)
+	}()
+
+	var err error
+
+	select {
+	case err = <-jobResult:
+	case err = <-podErr:
+	}
+
+	if errors.Is(err, ErrJobFailed) {
+		return jobError(ctx, r.Clientset, namespace, name, err)
+	}
+
+	if ctxErr := ctx.Err(); errors.Is(ctxErr, context.DeadlineExceeded) {
+		return fmt.Errorf("could not wait for sync job %s: %w", name, ctxErr)
+	}
+
+	return err
+}
+
+// Cleanup deletes the sync jobs in namespace that have succeeded or failed,
+// except for the newest successful and failed jobs within the retention
+// limits. The jobs created by a cron job are kept, since they are deleted
+// according to the history limits of the cron job.
+// It returns the names of the deleted jobs.
+func (r *Runner) Cleanup(ctx context.Context, namespace string) ([]string, error) {
+	jobs, err := ListJobs(ctx, r.Clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	deletePolicy := metav1.DeletePropagationForeground
+	deleted := []string{}
+	successful, failed := 0, 0
+
+	for i := range jobs {
+		j := jobs[i]
+
+		if createdByCronJob(&j) {
+			continue
+		}
+
+		// jobs are sorted newest first, so the oldest jobs are deleted
+		switch JobStatus(&j) {
+		case StatusRunning:
+			continue
+		case StatusFailed:
+			failed++
+			if failed <= r.Retention.Failed {
+				continue
+			}
+		default:
+			successful++
+			if successful <= r.Retention.Successful {
+				continue
+			}
+		}
+
+		if err := r.Clientset.BatchV1().Jobs(namespace).Delete(ctx, j.Name, metav1.DeleteOptions{
+			PropagationPolicy: &deletePolicy,
+		}); err != nil {
+			return deleted, fmt.Errorf("could not delete batch job %s: %s", j.Name, err)
+		}
+
+		deleted = append(deleted, j.Name)
+	}
+
+	return deleted, nil
+}
+
+func (r *Runner) logf(format string, args ...interface{}) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
@@ -0,0 +1,278 @@
+package vaultsync
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "team"
+
+// testJob returns a sync job created age ago with the given status.
+func testJob(name string, age time.Duration, status string) *batchv1.Job {
+	j := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         testNamespace,
+			Labels:            map[string]string{"job": JobName},
+			Annotations:       map[string]string{AnnotationCreatedBy: "alice@host"},
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		},
+	}
+
+	switch status {
+	case StatusRunning:
+		j.Status.Active = 1
+	case StatusSucceeded:
+		j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+	case StatusFailed:
+		j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: v1.ConditionTrue}}
+	}
+
+	return j
+}
+
+func jobNames(t *testing.T, clientset *fake.Clientset) []string {
+	jobs, err := clientset.BatchV1().Jobs(testNamespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	names := []string{}
+	for i := range jobs.Items {
+		names = append(names, jobs.Items[i].Name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestRunnerCreate(t *testing.T) {
+	var tt = []struct {
+		name         string
+		objects      []runtime.Object
+		runner       Runner
+		expectedErr  error
+		expectedJobs []string
+	}{
+		{
+			"retention",
+			[]runtime.Object{
+				testJob("vault-sync-1", 4*time.Minute, StatusSucceeded),
+				testJob("vault-sync-2", 3*time.Minute, StatusFailed),
+				testJob("vault-sync-3", 2*time.Minute, StatusSucceeded),
+				testJob("vault-sync-4", time.Minute, StatusFailed),
+			},
+			Runner{Retention: Retention{Successful: 1, Failed: 1}},
+			nil,
+			[]string{"vault-sync-3", "vault-sync-4", "vault-sync-new"},
+		},
+		{
+			"cron job",
+			[]runtime.Object{
+				cronJobOwned(testJob("vault-sync-cron-1", 2*time.Minute, StatusSucceeded)),
+				cronJobOwned(testJob("vault-sync-cron-2", time.Minute, StatusFailed)),
+			},
+			Runner{},
+			nil,
+			[]string{"vault-sync-cron-1", "vault-sync-cron-2", "vault-sync-new"},
+		},
+		{
+			"running",
+			[]runtime.Object{testJob("vault-sync-1", time.Minute, StatusRunning)},
+			Runner{},
+			ErrRunning,
+			[]string{"vault-sync-1"},
+		},
+		{
+			"pending",
+			[]runtime.Object{testJob("vault-sync-pending", time.Second, "")},
+			Runner{},
+			ErrRunning,
+			[]string{"vault-sync-pending"},
+		},
+		{
+			"running cron job",
+			[]runtime.Object{cronJobOwned(testJob("vault-sync-cron-1", time.Minute, StatusRunning))},
+			Runner{},
+			ErrRunning,
+			[]string{"vault-sync-cron-1"},
+		},
+		{
+			"replace",
+			[]runtime.Object{testJob("vault-sync-1", time.Minute, StatusRunning)},
+			Runner{Replace: true},
+			nil,
+			[]string{"vault-sync-new"},
+		},
+		{
+			"locked",
+			[]runtime.Object{heldLease("bob@host")},
+			Runner{},
+			ErrLocked,
+			[]string{},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tc.objects...)
+			r := tc.runner
+			r.Clientset = clientset
+
+			_, err := r.Create(context.Background(), testNamespace, testJob("vault-sync-new", 0, ""))
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expectedJobs, jobNames(t, clientset))
+		})
+	}
+}
+
+func TestRunnerCreateLockedWithoutAcquireTime(t *testing.T) {
+	// a lease written by another client may only have a renew time
+	lease := heldLease("bob@host")
+	lease.Spec.AcquireTime = nil
+
+	clientset := fake.NewSimpleClientset(lease)
+	r := Runner{Clientset: clientset}
+
+	_, err := r.Create(context.Background(), testNamespace, testJob("vault-sync-new", 0, ""))
+	require.ErrorIs(t, err, ErrLocked)
+	assert.Contains(t, err.Error(), "held by bob@host since "+lease.Spec.RenewTime.Format(time.RFC3339))
+}
+
+func TestRunnerCreateCanceled(t *testing.T) {
+	clientset := fake.NewSimpleClientset(heldLease("bob@host"))
+	r := Runner{Clientset: clientset, WaitForRunning: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := r.Create(ctx, testNamespace, testJob("vault-sync-new", 0, ""))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, jobNames(t, clientset))
+}
+
+func TestRunnerCleanup(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		testJob("vault-sync-1", 3*time.Minute, StatusSucceeded),
+		testJob("vault-sync-2", 2*time.Minute, StatusFailed),
+		testJob("vault-sync-running", time.Minute, StatusRunning),
+		testJob("vault-sync-pending", time.Second, ""),
+	)
+	r := Runner{Clientset: clientset}
+
+	deleted, err := r.Cleanup(context.Background(), testNamespace)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vault-sync-2", "vault-sync-1"}, deleted)
+	assert.Equal(t, []string{"vault-sync-pending", "vault-sync-running"}, jobNames(t, clientset), "unfinished jobs must be kept")
+}
+
+// cronJobOwned sets a cron job as controller of j.
+func cronJobOwned(j *batchv1.Job) *batchv1.Job {
+	controller := true
+	j.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "batch/v1",
+		Kind:       "CronJob",
+		Name:       JobName,
+		Controller: &controller,
+	}}
+
+	return j
+}
+
+// heldLease returns a lock held by holder.
+func heldLease(holder string) *coordinationv1.Lease {
+	now := metav1.NowMicro()
+	seconds := int32(LeaseDuration.Seconds())
+
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: JobName, Namespace: testNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+}
+
+func TestRunnerWait(t *testing.T) {
+	failedPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vault-sync-1-abcde",
+			Namespace: testNamespace,
+			Labels:    map[string]string{"job-name": "vault-sync-1"},
+		},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name: "vault-sync",
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+					ExitCode: 1,
+					Message:  "permission denied",
+				}},
+			}},
+		},
+	}
+
+	var tt = []struct {
+		name        string
+		status      string
+		expectedErr error
+	}{
+		{"succeeded", StatusSucceeded, nil},
+		{"failed", StatusFailed, ErrJobFailed},
+		{"timeout", "", context.DeadlineExceeded},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(testJob("vault-sync-1", 0, StatusRunning), failedPod)
+			jobWatch := watch.NewFake()
+			clientset.PrependWatchReactor("jobs", k8stesting.DefaultWatchReactor(jobWatch, nil))
+
+			if tc.status != "" {
+				go jobWatch.Modify(testJob("vault-sync-1", 0, tc.status))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			r := Runner{Clientset: clientset}
+
+			err := r.Wait(ctx, testNamespace, "vault-sync-1")
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, tc.expectedErr)
+
+			if tc.status == StatusFailed {
+				var containerErr *ContainerError
+				require.ErrorAs(t, err, &containerErr)
+				assert.Equal(t, "vault-sync", containerErr.Container)
+				assert.Equal(t, "vault-sync-1", containerErr.Job)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Package vaultsync builds and runs the batch jobs that synchronize vault
+// secrets into kubernetes secrets. It is the API behind the kubectl
+// vault_sync plugin and can be used by other tools to create the same sync
+// jobs:
+//
+//	cfg, _, err := vaultsync.NewResolver().Resolve(ns)
+//	if err != nil {
+//		return err
+//	}
+//
+//	j, err := (&vaultsync.Builder{Suffix: vaultsync.NewSuffix(time.Now())}).Build(cfg)
+//	if err != nil {
+//		return err
+//	}
+//
+//	r := &vaultsync.Runner{Clientset: clientset}
+//
+//	created, err := r.Create(ctx, ns.Name, j)
+//	if err != nil {
+//		return err
+//	}
+//
+//	return r.Wait(ctx, ns.Name, created.Name)
+package vaultsync
+
+import (
+	"errors"
+
+	"github.com/postfinance/kubectl-vault_sync/internal/job"
+)
+
+// JobName is the name of the sync jobs without suffix and the value of
+// their label 'job'.
+const JobName = job.Name
+
+// The namespace annotations configuring the synchronization. The names of
+// the settings are the annotation names without prefix.
+const (
+	AnnotationPrefix             = "sync.vault.postfinance.ch/"
+	AnnotationSecretsPath        = AnnotationPrefix + "secrets-path"   // nolint: gosec
+	AnnotationSecretsPrefix      = AnnotationPrefix + "secrets-prefix" // nolint: gosec
+	AnnotationRole               = AnnotationPrefix + "role"
+	AnnotationAddr               = AnnotationPrefix + "addr"
+	AnnotationMountPath          = AnnotationPrefix + "mount-path"
+	AnnotationSyncImage          = AnnotationPrefix + "sync-image"
+	AnnotationAuthImage          = AnnotationPrefix + "auth-image"
+	AnnotationTrustSecret        = AnnotationPrefix + "trust-secret" // nolint: gosec
+	AnnotationVaultNamespace     = AnnotationPrefix + "vault-namespace"
+	AnnotationVaultAuthNamespace = AnnotationPrefix + "vault-auth-namespace"
+	AnnotationAuthMethod         = AnnotationPrefix + "auth-method"
+	AnnotationJWTAudience        = AnnotationPrefix + "jwt-audience"
+	AnnotationAppRoleSecret      = AnnotationPrefix + "approle-secret" // nolint: gosec
+	AnnotationRequests           = AnnotationPrefix + "requests"
+	AnnotationLimits             = AnnotationPrefix + "limits"
+	AnnotationNodeSelector       = AnnotationPrefix + "node-selector"
+	AnnotationTolerations        = AnnotationPrefix + "tolerations"
+	AnnotationAffinity           = AnnotationPrefix + "affinity"
+	AnnotationPriorityClassName  = AnnotationPrefix + "priority-class-name"
+	AnnotationActiveDeadline     = AnnotationPrefix + "active-deadline"
+	AnnotationHardened           = AnnotationPrefix + "hardened"
+	AnnotationKeepSuccessful     = AnnotationPrefix + "keep-successful"
+	AnnotationKeepFailed         = AnnotationPrefix + "keep-failed"
+	AnnotationTTL                = AnnotationPrefix + "ttl"
+	AnnotationBackoffLimit       = AnnotationPrefix + "backoff-limit"
+	AnnotationPatchConfigMap     = AnnotationPrefix + "patch-configmap"
+
+	// AnnotationCreatedBy records who created a sync job.
+	AnnotationCreatedBy = AnnotationPrefix + "created-by"
+)
+
+// The vault auth methods of the init container.
+const (
+	AuthKubernetes = "kubernetes"
+	AuthJWT        = "jwt"
+	AuthAppRole    = "approle"
+)
+
+// AuthMethods are the supported vault auth methods.
+var AuthMethods = []string{AuthKubernetes, AuthJWT, AuthAppRole}
+
+// The default settings.
+const (
+	DefaultSyncImage      = "postfinance/vault-kubernetes-synchronizer:latest"
+	DefaultAuthImage      = "postfinance/vault-kubernetes-authenticator:latest"
+	DefaultCLIImage       = "hashicorp/vault:1.15.6"
+	DefaultMountPath      = "kubernetes"
+	DefaultSecretsPrefix  = "v3t-"
+	DefaultAuthMethod     = AuthKubernetes
+	DefaultJWTAudience    = "vault"
+	DefaultHardened       = "true"
+	DefaultTTL            = "1h"
+	DefaultBackoffLimit   = 2
+	DefaultKeepSuccessful = 0
+	DefaultKeepFailed     = 1
+)
+
+// RootNamespace is the name of the root namespace of vault enterprise.
+const RootNamespace = "root"
+
+var (
+	// ErrNotConfigured is returned if a required setting is missing.
+	ErrNotConfigured = errors.New("not configured for vault synchronization")
+	// ErrLocked is returned if the lock of a namespace is held by someone else.
+	ErrLocked = errors.New("synchronization is locked")
+	// ErrRunning is returned if a sync job is already running.
+	ErrRunning = errors.New("sync job is already running")
+	// ErrJobFailed is returned if a sync job has failed.
+	ErrJobFailed = errors.New("vault-sync job failed")
+)
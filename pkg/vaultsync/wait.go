@@ -0,0 +1,262 @@
+package vaultsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// failureLogLines is the number of log lines shown for a failed container.
+const failureLogLines = 10
+
+// fatalWaitingReasons are reasons of waiting containers that do not resolve
+// without user interaction.
+var fatalWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// ContainerError describes why a container of a sync job failed. It wraps
+// ErrJobFailed.
+type ContainerError struct {
+	Job       string
+	Pod       string
+	Container string
+	Reason    string
+	Message   string
+	ExitCode  int32
+	// Logs are the last log lines of a terminated container.
+	Logs string
+	// Waiting is true if the container can not be started.
+	Waiting bool
+}
+
+func (e *ContainerError) Error() string {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "vault-sync job %s failed: container %s in pod %s ", e.Job, e.Container, e.Pod)
+
+	if e.Waiting {
+		fmt.Fprintf(b, "can not start: %s", e.Reason)
+	} else {
+		fmt.Fprintf(b, "terminated with exit code %d", e.ExitCode)
+
+		if e.Reason != "" {
+			fmt.Fprintf(b, " (%s)", e.Reason)
+		}
+	}
+
+	if e.Message != "" {
+		fmt.Fprintf(b, ": %s", strings.TrimSpace(e.Message))
+	}
+
+	if e.Logs != "" {
+		fmt.Fprintf(b, "\nlast log lines of container %s:\n%s", e.Container, strings.TrimRight(e.Logs, "\n"))
+	}
+
+	return b.String()
+}
+
+func (e *ContainerError) Unwrap() error {
+	return ErrJobFailed
+}
+
+// PodError returns the error of the first (init) container of pod that has
+// terminated with a non-zero exit code or that can not be started. It
+// returns nil if there is none.
+func PodError(pod *v1.Pod) *ContainerError {
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for i := range statuses {
+			s := statuses[i]
+
+			switch {
+			case s.State.Waiting != nil && fatalWaitingReasons[s.State.Waiting.Reason]:
+				return &ContainerError{
+					Job:       pod.Labels["job-name"],
+					Pod:       pod.Name,
+					Container: s.Name,
+					Reason:    s.State.Waiting.Reason,
+					Message:   s.State.Waiting.Message,
+					Waiting:   true,
+				}
+			case s.State.Terminated != nil && s.State.Terminated.ExitCode != 0:
+				return &ContainerError{
+					Job:       pod.Labels["job-name"],
+					Pod:       pod.Name,
+					Container: s.Name,
+					Reason:    s.State.Terminated.Reason,
+					Message:   s.State.Terminated.Message,
+					ExitCode:  s.State.Terminated.ExitCode,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchJob waits until the job with the given name has finished. It returns
+// an error wrapping ErrJobFailed if the job has failed.
+func watchJob(ctx context.Context, clientset kubernetes.Interface, namespace, name string) error {
+	client := clientset.BatchV1().Jobs(namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return client.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return client.Watch(ctx, options)
+		},
+	}
+
+	var final *batchv1.Job
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &batchv1.Job{}, nil, func(e watch.Event) (bool, error) {
+		j, ok := e.Object.(*batchv1.Job)
+		if !ok || j.Name != name {
+			return false, nil
+		}
+
+		if e.Type == watch.Deleted {
+			return false, fmt.Errorf("batch job %s has been deleted", name)
+		}
+
+		final = j
+
+		return JobStatus(j) != StatusRunning, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if JobStatus(final) == StatusFailed {
+		for _, c := range final.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Reason != "" {
+				return fmt.Errorf("%w: %s: %s", ErrJobFailed, c.Reason, c.Message)
+			}
+		}
+
+		return ErrJobFailed
+	}
+
+	return nil
+}
+
+// watchJobPods watches the pods of the job with the given name until the
+// context is done. It returns a *ContainerError as soon as a container can
+// not be started.
+func watchJobPods(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) error {
+	client := clientset.CoreV1().Pods(namespace)
+	selector := fmt.Sprintf("job-name=%s", jobName)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return client.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return client.Watch(ctx, options)
+		},
+	}
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1.Pod{}, nil, func(e watch.Event) (bool, error) {
+		pod, ok := e.Object.(*v1.Pod)
+		if !ok || e.Type == watch.Deleted {
+			return false, nil
+		}
+
+		if err := PodError(pod); err != nil && err.Waiting {
+			err.Job = jobName
+			return false, err
+		}
+
+		return false, nil
+	})
+
+	return err
+}
+
+// newestPodError returns the error of the failed container of the job's
+// newest pod including its last log lines. It returns nil if no failed
+// container is found.
+func newestPodError(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string) *ContainerError {
+	pods, err := JobPods(ctx, clientset, namespace, jobName)
+	if err != nil {
+		return nil
+	}
+
+	for i := range pods {
+		e := PodError(&pods[i])
+		if e == nil {
+			continue
+		}
+
+		e.Job = jobName
+
+		if !e.Waiting {
+			e.Logs = tailContainerLogs(ctx, clientset, &pods[i], e.Container, failureLogLines)
+		}
+
+		return e
+	}
+
+	return nil
+}
+
+// tailContainerLogs returns the last lines of the container's logs. Errors
+// are ignored, since the logs are only used to enrich error messages.
+func tailContainerLogs(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, container string, lines int64) string {
+	stream, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+		Container: container,
+		TailLines: &lines,
+	}).Stream(ctx)
+	if err != nil {
+		return ""
+	}
+	defer stream.Close()
+
+	buf := &bytes.Buffer{}
+	if _, err := io.Copy(buf, stream); err != nil {
+		return ""
+	}
+
+	return buf.String()
+}
+
+// jobError returns the error of the failed container, if err is the
+// failure of job jobName.
+func jobError(ctx context.Context, clientset kubernetes.Interface, namespace, jobName string, err error) error {
+	if !errors.Is(err, ErrJobFailed) {
+		return err
+	}
+
+	var containerErr *ContainerError
+	if errors.As(err, &containerErr) {
+		return err
+	}
+
+	// look up the container that caused the failure
+	if containerErr = newestPodError(ctx, clientset, namespace, jobName); containerErr != nil {
+		return containerErr
+	}
+
+	return err
+}